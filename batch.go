@@ -3,14 +3,11 @@ package rockscache
 import (
 	"context"
 	"errors"
-	"fmt"
-	"math/rand"
-	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/lithammer/shortuuid"
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -18,76 +15,166 @@ var (
 	errNeedAsyncFetch = errors.New("need async fetch")
 )
 
-func (c *Client) luaGetBatch(ctx context.Context, keys []string, owner string) ([]interface{}, error) {
-	res, err := callLua(ctx, c.rdb, getBatchScript, keys, []interface{}{now(), now() + int64(c.Options.LockExpire/time.Second), owner})
-	debugf("luaGetBatch return: %v, %v", res, err)
-	if err != nil {
-		return nil, err
+func (c *Client) setBatch(ctx context.Context, keys []string, values []string, expires []time.Duration, owner string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	lost, err := c.store.Set(ctx, keys, values, expires, owner)
+	if err == nil && len(lost) > 0 {
+		err = lockLostError(lost, owner)
+		failuresFromContext(ctx).add(err, lost...)
 	}
-	return res.([]interface{}), nil
+	return err
 }
 
-func (c *Client) luaSetBatch(ctx context.Context, keys []string, values []string, expires []int, owner string) error {
-	var vals = make([]interface{}, 0, 2+len(values))
-	vals = append(vals, owner)
-	for _, v := range values {
-		vals = append(vals, v)
-	}
-	for _, ex := range expires {
-		vals = append(vals, ex)
+func (c *Client) getBatch(ctx context.Context, keys []string, owner string) ([]interface{}, error) {
+	ctx, span := c.startSpan(ctx, "rockscache.getBatch", attribute.Int("rockscache.batch_size", len(keys)), attribute.String("rockscache.owner", owner))
+	rs, err := c.store.Get(ctx, keys, c.Options.LockExpire, owner)
+	endSpan(span, err)
+	if rec := infoFromContext(ctx); rec != nil && err == nil {
+		for i, r := range rs {
+			rec.got(keys[i], r.([]interface{}))
+		}
 	}
-	_, err := callLua(ctx, c.rdb, setBatchScript, keys, vals)
-	return err
+	return rs, err
 }
 
-func (c *Client) fetchBatch(ctx context.Context, keys []string, idxs []int, expire time.Duration, owner string, fn func(idxs []int) (map[int]string, error)) (map[int]string, error) {
+// fetchBatch fetches the values of keys[idxs] locked by owner, and stores them for expire, or the ttls returned by the loader of FetchBatchWithTTL.
+// if fn fails for some or all of idxs, see failBatch. if fn panics, all of idxs fail with ErrPanic.
+func (c *Client) fetchBatch(ctx context.Context, keys []string, idxs []int, expire time.Duration, owner string, lockedAt time.Time, stale map[int]*staleValue, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (result map[int]string, err error) {
+	ctx, span := c.startSpan(ctx, "rockscache.fetchBatch", attribute.Int("rockscache.batch_size", len(idxs)), attribute.String("rockscache.owner", owner))
+	defer func() { endSpan(span, err) }()
 	defer func() {
 		if r := recover(); r != nil {
-			debug.PrintStack()
+			c.reportPanic(r)
+			result, err = c.failBatch(ctx, keys, idxs, owner, stale, failAll(idxs, panicError(r)))
 		}
 	}()
-	data, err := fn(idxs)
+	lockedKeys := make([]string, 0, len(idxs))
+	for _, idx := range idxs {
+		lockedKeys = append(lockedKeys, keys[idx])
+	}
+	fnCtx, ttls := withTTLRecorder(withoutFailures(ctx))
+	keeper := c.keepLock(fnCtx, lockedKeys, owner, lockedAt)
+	defer keeper.stop()
+	began := time.Now()
+	data, err := fn(keeper.ctx, idxs)
+	data, failed, err := splitFailed(data, idxs, err)
+	c.metrics().Fetched(lockedKeys, time.Since(began), err)
 	if err != nil {
+		return c.failBatch(ctx, keys, idxs, owner, stale, failAll(idxs, err))
+	}
+	var fetchedIdxs = idxs
+	if len(failed) > 0 {
+		fetchedIdxs = make([]int, 0, len(idxs))
 		for _, idx := range idxs {
-			_ = c.UnlockForUpdate(ctx, keys[idx], owner)
+			if failed[idx] == nil {
+				fetchedIdxs = append(fetchedIdxs, idx)
+			}
 		}
-		return nil, err
-	}
-
-	if data == nil {
-		// incase data is nil
-		data = make(map[int]string)
 	}
+	c.refreshFailures.reset(pickKeys(keys, fetchedIdxs)...)
 
+	// the values of fetchedIdxs, data is not changed because it may be kept by fn
+	result = make(map[int]string, len(idxs))
 	var batchKeys []string
 	var batchValues []string
-	var batchExpires []int
+	var batchExpires []time.Duration
 
-	for _, idx := range idxs {
+	for _, idx := range fetchedIdxs {
 		v := data[idx]
-		ex := expire - c.Options.Delay - time.Duration(rand.Float64()*c.Options.RandomExpireAdjustment*float64(expire))
-		if v == "" {
+		result[idx] = v // incase idx not in data
+		ex := c.expireFor(ttls.expire(idx, expire))
+		if v == notFoundValue {
+			if c.Options.NotFoundExpire == 0 { // if not found expire is 0, then delete the key
+				if err := c.store.Del(ctx, keys[idx]); err != nil {
+					c.logger().Warn("delete not found key failed", "key", keys[idx], "err", err)
+				}
+				infoFromContext(ctx).stored(keys[idx], 0, false)
+				continue
+			}
+			ex = c.Options.NotFoundExpire
+		} else if v == "" {
 			if c.Options.EmptyExpire == 0 { // if empty expire is 0, then delete the key
-				_ = c.rdb.Del(ctx, keys[idx]).Err()
-				if err != nil {
-					debugf("batch: del failed key=%s err:%s", keys[idx], err.Error())
+				if err := c.store.Del(ctx, keys[idx]); err != nil {
+					c.logger().Warn("delete empty key failed", "key", keys[idx], "err", err)
 				}
+				infoFromContext(ctx).stored(keys[idx], 0, false)
 				continue
 			}
+			c.metrics().EmptyCached(keys[idx])
 			ex = c.Options.EmptyExpire
-
-			data[idx] = v // incase idx not in data
 		}
 		batchKeys = append(batchKeys, keys[idx])
 		batchValues = append(batchValues, v)
-		batchExpires = append(batchExpires, int(ex/time.Second))
+		batchExpires = append(batchExpires, ex)
 	}
 
-	err = c.luaSetBatch(ctx, batchKeys, batchValues, batchExpires, owner)
+	err = c.setBatch(ctx, batchKeys, batchValues, batchExpires, owner)
+	if rec := infoFromContext(ctx); rec != nil && err == nil {
+		for i, key := range batchKeys {
+			rec.stored(key, batchExpires[i], batchValues[i] == "")
+		}
+	}
+	if err != nil && !errors.Is(err, ErrLockLost) {
+		c.logger().Warn("set batch failed", "keys", batchKeys, "owner", owner, "err", err)
+		err = nil
+	}
+	if len(failed) > 0 {
+		values, ferr := c.failBatch(ctx, keys, sortedIdxs(failed), owner, stale, failed)
+		if ferr != nil && !valuesReturned(ferr) {
+			return nil, ferr
+		}
+		for idx, v := range values {
+			result[idx] = v
+		}
+		err = joinReturned(err, ferr)
+	}
+	return result, err
+}
+
+// asyncFetchBatch refreshes the stale values of keys[idxs] in background
+func (c *Client) asyncFetchBatch(ctx context.Context, keys []string, idxs []int, expire time.Duration, owner string, lockedAt time.Time, stale map[int]*staleValue, fn func(ctx context.Context, idxs []int) (map[int]string, error)) {
+	c.logger().Debug("batch weak: async fetch", "keys", pickKeys(keys, idxs))
+	ctx, cancel := c.asyncContext(ctx)
+	defer cancel()
+	ctx, span := c.startAsyncSpan(ctx, "rockscache.asyncRefreshBatch", attribute.Int("rockscache.batch_size", len(idxs)))
+	_, err := c.fetchChunks(ctx, keys, idxs, expire, owner, lockedAt, stale, fn)
+	endSpan(span, err)
 	if err != nil {
-		debugf("batch: luaSetBatch failed keys=%s err:%s", keys, err.Error())
+		c.asyncRefreshFailed(pickKeys(keys, idxs), owner, err)
+	}
+}
+
+// refreshBatch refreshes the stale values of keys[idxs] in background, while the stale values are returned,
+// and returns the idxs to fetch synchronously, if the refresh can not be queued.
+func (c *Client) refreshBatch(ctx context.Context, keys []string, idxs []int, expire time.Duration, owner string, lockedAt time.Time, stale map[int]*staleValue, fn func(ctx context.Context, idxs []int) (map[int]string, error)) []int {
+	var refreshStale = make(map[int]*staleValue, len(idxs)) // stale is still written by the caller
+	for _, idx := range idxs {
+		if s := stale[idx]; s != nil {
+			refreshStale[idx] = s
+		}
+	}
+	syncIdxs := c.submitRefresh(keys, idxs, owner, func(idxs []int) {
+		c.asyncFetchBatch(ctx, keys, idxs, expire, owner, lockedAt, refreshStale, fn)
+	})
+	var toSync = make(map[int]bool, len(syncIdxs))
+	for _, idx := range syncIdxs {
+		toSync[idx] = true
 	}
-	return data, nil
+	for _, idx := range idxs {
+		if !toSync[idx] {
+			c.staleServed(ctx, keys[idx])
+		}
+	}
+	return syncIdxs
+}
+
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
 }
 
 func (c *Client) keysIdx(keys []string) (idxs []int) {
@@ -101,16 +188,31 @@ type pair struct {
 	idx  int
 	data string
 	err  error
+	// fresh is true if the data is not being refreshed, and can be stored in local cache
+	fresh bool
+	// lockedAt is the time before the key is locked, if err is errNeedFetch or errNeedAsyncFetch
+	lockedAt time.Time
+	// stale is the stale value which may be returned if fn fails, if err is errNeedFetch or errNeedAsyncFetch
+	stale *staleValue
 }
 
-func (c *Client) weakFetchBatch(ctx context.Context, keys []string, expire time.Duration, fn func(idxs []int) (map[int]string, error)) (map[int]string, error) {
-	debugf("batch: weakFetch keys=%+v", keys)
+func (c *Client) weakFetchBatch(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]string, error) {
+	c.logger().Debug("batch: weakFetch", "keys", keys)
 	var result = make(map[int]string)
+	version := c.local.currentVersion()
 	owner := shortuuid.New()
 	var toGet, toFetch, toFetchAsync []int
+	var lostErr error // the values are returned with ErrLockLost if some of them are not stored, or ErrStale
+	// stale is the stale values of the keys to fetch, which may be returned if fn fails
+	var stale = make(map[int]*staleValue)
+	// failures collects the errors of the keys for FetchBatchResults, instead of failing the whole batch
+	failures := failuresFromContext(ctx)
+	// stream delivers the values of the keys for FetchBatchStream, as soon as they are known
+	stream := streamFromContext(ctx)
 
 	// read from redis without sleep
-	rs, err := c.luaGetBatch(ctx, keys, owner)
+	lockedAt := time.Now()
+	rs, err := c.getBatch(ctx, keys, owner)
 	if err != nil {
 		return nil, err
 	}
@@ -127,30 +229,55 @@ func (c *Client) weakFetchBatch(ctx context.Context, keys []string, expire time.
 		}
 
 		if r[1] == locked {
+			if s := c.staleOf(r); s != nil {
+				stale[i] = s
+			}
+		}
+		if r[1] == locked && c.refreshFailing(keys[i]) { // the async refresh keeps failing, fetch synchronously
+			toFetch = append(toFetch, i)
+			continue
+		} else if r[1] == locked {
 			toFetchAsync = append(toFetchAsync, i)
 			// fallthrough with old data
-		} // else new data
+		} else if r[1] == nil { // new data, not being refreshed by other
+			c.hit(ctx, keys[i])
+			c.local.set(keys[i], r[0].(string), version)
+			stream.send(keys[i], r[0].(string), nil)
+		} else { // old data, being refreshed by other
+			c.staleServed(ctx, keys[i])
+			stream.send(keys[i], r[0].(string), nil)
+		}
 
 		result[i] = r[0].(string)
 	}
 
 	if len(toFetchAsync) > 0 {
-		go func(idxs []int) {
-			debugf("batch weak: async fetch keys=%+v", keys)
-			_, _ = c.fetchBatch(ctx, keys, idxs, expire, owner, fn)
-		}(toFetchAsync)
+		syncIdxs := c.refreshBatch(ctx, keys, toFetchAsync, expire, owner, lockedAt, stale, fn)
+		stream.sendServed(keys, toFetchAsync, syncIdxs, result)
+		toFetch = append(toFetch, syncIdxs...)
 		toFetchAsync = toFetchAsync[:0] // reset toFetch
 	}
 
 	if len(toFetch) > 0 {
 		// batch fetch
-		fetched, err := c.fetchBatch(ctx, keys, toFetch, expire, owner, fn)
-		if err != nil {
+		for _, idx := range toFetch {
+			c.miss(ctx, keys[idx])
+		}
+		fetched, err := c.fetchChunks(ctx, keys, toFetch, expire, owner, lockedAt, stale, fn)
+		if err != nil && !valuesReturned(err) {
 			return nil, err
 		}
 		for _, k := range toFetch {
-			result[k] = fetched[k]
+			v, ok := fetched[k]
+			if !ok { // failed for FetchBatchResults
+				continue
+			}
+			result[k] = v
+			if err == nil && failures.get(keys[k]) == nil {
+				c.setLocalFetched(keys[k], v, version)
+			}
 		}
+		lostErr = joinReturned(lostErr, err)
 		toFetch = toFetch[:0] // reset toFetch
 	}
 
@@ -163,111 +290,214 @@ func (c *Client) weakFetchBatch(ctx context.Context, keys []string, expire time.
 			wg.Add(1)
 			go func(i int) {
 				defer wg.Done()
-				r, err := c.luaGet(ctx, keys[i], owner)
-				for err == nil && r[0] == nil && r[1].(string) != locked {
-					debugf("batch weak: empty result for %s locked by other, so sleep %s", keys[i], c.Options.LockSleep.String())
-					select {
-					case <-ctx.Done():
-						ch <- pair{idx: i, err: ctx.Err()}
+				w := c.newLockWaiter(keys[i])
+				defer w.stop()
+				lockedAt := time.Now()
+				r, err := c.get(ctx, keys[i], owner)
+				for err == nil && r[0] == nil && r[1].(string) != locked && cachedError(r) == nil {
+					c.logger().Debug("batch weak: empty result locked by other, so sleep", "key", keys[i], "sleep", c.Options.LockSleep)
+					if err := w.wait(ctx); err != nil {
+						ch <- pair{idx: i, err: err}
 						return
-					case <-time.After(c.Options.LockSleep):
-						// equal to time.Sleep(c.Options.LockSleep) but can be canceled
 					}
-					r, err = c.luaGet(ctx, keys[i], owner)
+					lockedAt = time.Now()
+					r, err = c.get(ctx, keys[i], owner)
 				}
 				if err != nil {
 					ch <- pair{idx: i, data: "", err: err}
 					return
 				}
+				if r[0] == nil && r[1] != locked { // the error of fn is cached by other
+					ch <- pair{idx: i, err: cachedError(r)}
+					return
+				}
 				if r[1] != locked { // normal value
-					ch <- pair{idx: i, data: r[0].(string), err: nil}
+					stream.send(keys[i], r[0].(string), nil)
+					ch <- pair{idx: i, data: r[0].(string), err: nil, fresh: r[1] == nil}
 					return
 				}
 				if r[0] == nil {
-					ch <- pair{idx: i, data: "", err: errNeedFetch}
+					ch <- pair{idx: i, data: "", err: errNeedFetch, lockedAt: lockedAt}
 					return
 				}
-				ch <- pair{idx: i, data: "", err: errNeedAsyncFetch}
+				ch <- pair{idx: i, data: r[0].(string), err: errNeedAsyncFetch, lockedAt: lockedAt, stale: c.staleOf(r)}
 			}(idx)
 		}
 		wg.Wait()
 		close(ch)
 
+		lockedAt = time.Now() // the earliest time the keys to fetch are locked
 		for p := range ch {
+			if p.err == errNeedFetch || p.err == errNeedAsyncFetch {
+				lockedAt = earliest(lockedAt, p.lockedAt)
+				if p.stale != nil {
+					stale[p.idx] = p.stale
+				}
+			}
 			if p.err != nil {
 				switch p.err {
 				case errNeedFetch:
 					toFetch = append(toFetch, p.idx)
 					continue
 				case errNeedAsyncFetch:
-					toFetchAsync = append(toFetchAsync, p.idx)
+					result[p.idx] = p.data // the stale value is returned while refreshing
+					if c.refreshFailing(keys[p.idx]) {
+						toFetch = append(toFetch, p.idx)
+					} else {
+						toFetchAsync = append(toFetchAsync, p.idx)
+					}
 					continue
 				default:
 				}
+				if failures.add(p.err, keys[p.idx]) {
+					continue
+				}
 				return nil, p.err
 			}
 			result[p.idx] = p.data
+			if p.fresh {
+				c.hit(ctx, keys[p.idx])
+				c.local.set(keys[p.idx], p.data, version)
+			} else {
+				c.staleServed(ctx, keys[p.idx])
+			}
 		}
 	}
 
 	if len(toFetchAsync) > 0 {
-		go func(idxs []int) {
-			debugf("batch weak: async 2 fetch keys=%+v", keys)
-			_, _ = c.fetchBatch(ctx, keys, idxs, expire, owner, fn)
-		}(toFetchAsync)
+		syncIdxs := c.refreshBatch(ctx, keys, toFetchAsync, expire, owner, lockedAt, stale, fn)
+		stream.sendServed(keys, toFetchAsync, syncIdxs, result)
+		toFetch = append(toFetch, syncIdxs...)
 	}
 
 	if len(toFetch) > 0 {
 		// batch fetch
-		fetched, err := c.fetchBatch(ctx, keys, toFetch, expire, owner, fn)
-		if err != nil {
+		for _, idx := range toFetch {
+			c.miss(ctx, keys[idx])
+		}
+		fetched, err := c.fetchChunks(ctx, keys, toFetch, expire, owner, lockedAt, stale, fn)
+		if err != nil && !valuesReturned(err) {
 			return nil, err
 		}
 		for _, k := range toFetch {
-			result[k] = fetched[k]
+			v, ok := fetched[k]
+			if !ok { // failed for FetchBatchResults
+				continue
+			}
+			result[k] = v
+			if err == nil && failures.get(keys[k]) == nil {
+				c.setLocalFetched(keys[k], v, version)
+			}
 		}
+		lostErr = joinReturned(lostErr, err)
 	}
 
-	return result, nil
+	return result, lostErr
+}
+
+// localFetchBatch returns the values found in local cache, and fetches the others by weakFetchBatch
+func (c *Client) localFetchBatch(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]string, error) {
+	var result = make(map[int]string)
+	var missKeys []string
+	var missIdxs []int
+	for i, key := range keys {
+		if v, ok := c.local.get(key); ok {
+			c.hit(ctx, key)
+			infoFromContext(ctx).localHit(key)
+			streamFromContext(ctx).send(key, v, nil)
+			result[i] = v
+			continue
+		}
+		missKeys = append(missKeys, key)
+		missIdxs = append(missIdxs, i)
+	}
+	c.logger().Debug("batch: local cache hit", "hits", len(keys)-len(missKeys), "keys", len(keys))
+	if len(missKeys) == 0 {
+		return result, nil
+	}
+	fetched, err := c.weakFetchBatch(ctx, missKeys, expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		origIdxs := make([]int, len(idxs))
+		for i, idx := range idxs {
+			origIdxs[i] = missIdxs[idx]
+		}
+		data, err := fn(ctx, origIdxs)
+		if err != nil {
+			return nil, err
+		}
+		var values = make(map[int]string)
+		for _, idx := range idxs {
+			if v, ok := data[missIdxs[idx]]; ok {
+				values[idx] = v
+			}
+		}
+		return values, nil
+	})
+	if err != nil && !valuesReturned(err) {
+		return nil, err
+	}
+	for idx, v := range fetched {
+		result[missIdxs[idx]] = v
+	}
+	// the stale indexes of missKeys are returned as the indexes of keys
+	return result, remapStale(err, missIdxs)
 }
 
-func (c *Client) strongFetchBatch(ctx context.Context, keys []string, expire time.Duration, fn func(idxs []int) (map[int]string, error)) (map[int]string, error) {
-	debugf("batch: strongFetch keys=%+v", keys)
+func (c *Client) strongFetchBatch(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]string, error) {
+	c.logger().Debug("batch: strongFetch", "keys", keys)
 	var result = make(map[int]string)
 	owner := shortuuid.New()
 	var toGet, toFetch []int
+	var lostErr error // the values are returned with ErrLockLost if some of them are not stored, or ErrStale
+	// stale is the stale values of the keys to fetch, which may be returned if fn fails
+	var stale = make(map[int]*staleValue)
+	// failures collects the errors of the keys for FetchBatchResults, instead of failing the whole batch
+	failures := failuresFromContext(ctx)
+	// stream delivers the values of the keys for FetchBatchStream, as soon as they are known
+	stream := streamFromContext(ctx)
 
 	// read from redis without sleep
-	rs, err := c.luaGetBatch(ctx, keys, owner)
+	lockedAt := time.Now()
+	rs, err := c.getBatch(ctx, keys, owner)
 	if err != nil {
 		return nil, err
 	}
 	for i, v := range rs {
 		r := v.([]interface{})
 		if r[1] == nil { // normal value
+			c.hit(ctx, keys[i])
+			stream.send(keys[i], r[0].(string), nil)
 			result[i] = r[0].(string)
 			continue
 		}
 
 		if r[1] != locked { // locked by other
-			debugf("batch: locked by other, continue idx=%d", i)
+			c.logger().Debug("batch: locked by other, continue", "key", keys[i])
 			toGet = append(toGet, i)
 			continue
 		}
 
 		// locked for fetch
+		if s := c.staleOf(r); s != nil {
+			stale[i] = s
+		}
 		toFetch = append(toFetch, i)
 	}
 
 	if len(toFetch) > 0 {
 		// batch fetch
-		fetched, err := c.fetchBatch(ctx, keys, toFetch, expire, owner, fn)
-		if err != nil {
+		for _, idx := range toFetch {
+			c.miss(ctx, keys[idx])
+		}
+		fetched, err := c.fetchChunks(ctx, keys, toFetch, expire, owner, lockedAt, stale, fn)
+		if err != nil && !valuesReturned(err) {
 			return nil, err
 		}
 		for _, k := range toFetch {
-			result[k] = fetched[k]
+			if v, ok := fetched[k]; ok {
+				result[k] = v
+			}
 		}
+		lostErr = joinReturned(lostErr, err)
 		toFetch = toFetch[:0] // reset toFetch
 	}
 
@@ -279,56 +509,89 @@ func (c *Client) strongFetchBatch(ctx context.Context, keys []string, expire tim
 			wg.Add(1)
 			go func(i int) {
 				defer wg.Done()
-				r, err := c.luaGet(ctx, keys[i], owner)
-				for err == nil && r[1] != nil && r[1] != locked { // locked by other
-					debugf("batch: locked by other, so sleep %s", c.Options.LockSleep)
-					select {
-					case <-ctx.Done():
-						ch <- pair{idx: i, err: ctx.Err()}
+				w := c.newLockWaiter(keys[i])
+				defer w.stop()
+				lockedAt := time.Now()
+				r, err := c.get(ctx, keys[i], owner)
+				for err == nil && r[1] != nil && r[1] != locked && cachedError(r) == nil { // locked by other
+					c.logger().Debug("batch: locked by other, so sleep", "key", keys[i], "sleep", c.Options.LockSleep)
+					if err := w.wait(ctx); err != nil {
+						ch <- pair{idx: i, err: err}
 						return
-					case <-time.After(c.Options.LockSleep):
-						// equal to time.Sleep(c.Options.LockSleep) but can be canceled
 					}
-					r, err = c.luaGet(ctx, keys[i], owner)
+					lockedAt = time.Now()
+					r, err = c.get(ctx, keys[i], owner)
 				}
 				if err != nil {
 					ch <- pair{idx: i, data: "", err: err}
 					return
 				}
+				if cerr := cachedError(r); cerr != nil { // the error of fn is cached by other
+					ch <- pair{idx: i, err: cerr, stale: c.staleOf(r)}
+					return
+				}
 				if r[1] != locked { // normal value
+					stream.send(keys[i], r[0].(string), nil)
 					ch <- pair{idx: i, data: r[0].(string), err: nil}
 					return
 				}
 				// locked for update
-				ch <- pair{idx: i, data: "", err: errNeedFetch}
+				ch <- pair{idx: i, data: "", err: errNeedFetch, lockedAt: lockedAt, stale: c.staleOf(r)}
 			}(idx)
 		}
 		wg.Wait()
 		close(ch)
+		lockedAt = time.Now() // the earliest time the keys to fetch are locked
 		for p := range ch {
 			if p.err != nil {
 				if p.err == errNeedFetch {
+					lockedAt = earliest(lockedAt, p.lockedAt)
+					if p.stale != nil {
+						stale[p.idx] = p.stale
+					}
 					toFetch = append(toFetch, p.idx)
 					continue
 				}
+				if errors.Is(p.err, ErrCached) {
+					v, err := c.serveCachedError(ctx, keys[p.idx], p.stale, p.err)
+					var se *StaleError
+					if errors.As(err, &se) {
+						result[p.idx] = v
+						if !failures.add(se, keys[p.idx]) {
+							se.Idxs = []int{p.idx}
+							lostErr = joinReturned(lostErr, se)
+						}
+						continue
+					}
+				}
+				if failures.add(p.err, keys[p.idx]) {
+					continue
+				}
 				return nil, p.err
 			}
+			c.hit(ctx, keys[p.idx])
 			result[p.idx] = p.data
 		}
 	}
 
 	if len(toFetch) > 0 {
 		// batch fetch
-		fetched, err := c.fetchBatch(ctx, keys, toFetch, expire, owner, fn)
-		if err != nil {
+		for _, idx := range toFetch {
+			c.miss(ctx, keys[idx])
+		}
+		fetched, err := c.fetchChunks(ctx, keys, toFetch, expire, owner, lockedAt, stale, fn)
+		if err != nil && !valuesReturned(err) {
 			return nil, err
 		}
 		for _, k := range toFetch {
-			result[k] = fetched[k]
+			if v, ok := fetched[k]; ok {
+				result[k] = v
+			}
 		}
+		lostErr = joinReturned(lostErr, err)
 	}
 
-	return result, nil
+	return result, lostErr
 }
 
 // FetchBatch returns a map with values indexed by index of keys list.
@@ -339,49 +602,68 @@ func (c *Client) strongFetchBatch(ctx context.Context, keys []string, expire tim
 // missing in cache, which can be used to form a batch query for missing data.
 // the return value of the batch data fetch function is a map, with key of the
 // index and value of the corresponding data in form of string
+// if ErrLockLost is returned, the values are returned too, but the values of the lost keys are not stored in cache.
+// if fn fails in StaleIfError mode, the stale values may be returned with a *StaleError, whose Idxs are the indexes of the stale values.
+// if fn returns ErrNotFound, the indexes not in its result are cached as not found for NotFoundExpire, and are not in the result.
+// if fn panics, the keys it is fetching are unlocked, and an error matching ErrPanic is returned. see FetchBatchResults for partial results.
 func (c *Client) FetchBatch(keys []string, expire time.Duration, fn func(idxs []int) (map[int]string, error)) (map[int]string, error) {
 	return c.FetchBatch2(c.Options.Context, keys, expire, fn)
 }
 
 // FetchBatch2 is same with FetchBatch, except that a user defined context.Context can be provided.
 func (c *Client) FetchBatch2(ctx context.Context, keys []string, expire time.Duration, fn func(idxs []int) (map[int]string, error)) (map[int]string, error) {
+	result, err := c.fetchKeys(ctx, keys, expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		return fn(idxs)
+	})
+	return dropNotFound(result), err
+}
+
+func (c *Client) fetchKeys(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (result map[int]string, err error) {
+	ctx, span, stats := c.startFetchSpan(ctx, "rockscache.FetchBatch", attribute.Int("rockscache.batch_size", len(keys)))
+	defer func() { endFetchSpan(span, stats, true, err) }()
 	if c.Options.DisableCacheRead {
-		return fn(c.keysIdx(keys))
+		if rec := infoFromContext(ctx); rec != nil {
+			for _, key := range keys {
+				rec.outcome(key, OutcomeMiss)
+			}
+		}
+		idxs := c.keysIdx(keys)
+		data, err := fn(withoutFailures(ctx), idxs)
+		data, failed, err := splitFailed(data, idxs, err)
+		for _, idx := range sortedIdxs(failed) {
+			if !failuresFromContext(ctx).add(failed[idx], keys[idx]) {
+				return nil, failed[idx]
+			}
+		}
+		return data, err
 	} else if c.Options.StrongConsistency {
 		return c.strongFetchBatch(ctx, keys, expire, fn)
+	} else if c.local != nil {
+		return c.localFetchBatch(ctx, keys, expire, fn)
 	}
 	return c.weakFetchBatch(ctx, keys, expire, fn)
 }
 
+// FetchBatchCtx is same with FetchBatch2, except that fn receives a context, which is canceled when ctx is done,
+// or the locks of the keys to fetch expire, so that fn is stopped before another owner may take the locks.
+func (c *Client) FetchBatchCtx(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]string, error) {
+	result, err := c.fetchKeys(ctx, keys, expire, fn)
+	return dropNotFound(result), err
+}
+
 // TagAsDeletedBatch a key list, the keys in list will expire after delay time.
 func (c *Client) TagAsDeletedBatch(keys []string) error {
 	return c.TagAsDeletedBatch2(c.Options.Context, keys)
 }
 
 // TagAsDeletedBatch2 a key list, the keys in list will expire after delay time.
-func (c *Client) TagAsDeletedBatch2(ctx context.Context, keys []string) error {
+func (c *Client) TagAsDeletedBatch2(ctx context.Context, keys []string) (err error) {
 	if c.Options.DisableCacheDelete {
 		return nil
 	}
-	debugf("batch deleting: keys=%v", keys)
-	luaFn := func(con redis.Scripter) error {
-		_, err := callLua(ctx, con, deleteBatchScript, keys, []interface{}{int64(c.Options.Delay / time.Second)})
-		return err
-	}
-	if c.Options.WaitReplicas > 0 {
-		err := luaFn(c.rdb)
-		cmd := redis.NewCmd(ctx, "WAIT", c.Options.WaitReplicas, c.Options.WaitReplicasTimeout)
-		if err == nil {
-			err = c.rdb.Process(ctx, cmd)
-		}
-		var replicas int
-		if err == nil {
-			replicas, err = cmd.Int()
-		}
-		if err == nil && replicas < c.Options.WaitReplicas {
-			err = fmt.Errorf("wait replicas %d failed. result replicas: %d", c.Options.WaitReplicas, replicas)
-		}
-		return err
-	}
-	return luaFn(c.rdb)
+	ctx, span := c.startSpan(ctx, "rockscache.TagAsDeletedBatch", attribute.Int("rockscache.batch_size", len(keys)))
+	defer func() { endSpan(span, err) }()
+	c.logger().Debug("batch deleting", "keys", keys)
+	c.local.del(keys...)
+	return c.store.TagAsDeleted(ctx, keys, c.deleteDelay())
 }
@@ -131,7 +131,9 @@ func TestWeakFetchBatchCanceled(t *testing.T) {
 	idxs := genIdxs(n)
 	keys, values1, values2 := genKeys(idxs), genValues(n, "value_"), genValues(n, "eulav_")
 	values3 := genValues(n, "vvvv_")
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		dc2 := NewClient(rdb, NewDefaultOptions())
 		v, err := dc2.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 450))
 		assert.Nil(t, err)
@@ -155,6 +157,7 @@ func TestWeakFetchBatchCanceled(t *testing.T) {
 	_, err = rc.FetchBatch2(ctx, keys, 60*time.Second, genBatchDataFunc(values3, 200))
 	assert.ErrorIs(t, err, context.Canceled)
 	assertEqualDuration(t, time.Duration(200)*time.Millisecond, time.Since(began))
+	<-done // the lock of dc2 should not be taken by the next test
 }
 
 func TestStrongFetchBatchCanceled(t *testing.T) {
@@ -165,7 +168,9 @@ func TestStrongFetchBatchCanceled(t *testing.T) {
 	idxs := genIdxs(n)
 	keys, values1, values2 := genKeys(idxs), genValues(n, "value_"), genValues(n, "eulav_")
 	values3 := genValues(n, "vvvv_")
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		dc2 := NewClient(rdb, NewDefaultOptions())
 		v, err := dc2.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 450))
 		assert.Nil(t, err)
@@ -189,4 +194,5 @@ func TestStrongFetchBatchCanceled(t *testing.T) {
 	_, err = rc.FetchBatch2(ctx, keys, 60*time.Second, genBatchDataFunc(values3, 200))
 	assert.ErrorIs(t, err, context.Canceled)
 	assertEqualDuration(t, time.Duration(200)*time.Millisecond, time.Since(began))
+	<-done // the lock of dc2 should not be taken by the next test
 }
@@ -12,7 +12,7 @@ import (
 
 func genBatchDataFunc(values map[int]string, sleepMilli int) func(idxs []int) (map[int]string, error) {
 	return func(idxs []int) (map[int]string, error) {
-		debugf("batch fetching: %v", idxs)
+		stdLogger{}.Debug("batch fetching", "idxs", idxs)
 		time.Sleep(time.Duration(sleepMilli) * time.Millisecond)
 		return values, nil
 	}
@@ -208,11 +208,12 @@ func TestStrongFetchBatchOverlapExpire(t *testing.T) {
 	keys1, values1 := keys[:60], genValues(60, "value_")
 	keys2, values2 := keys[40:], genValues(60, "eulav_")
 
-	v, err := rc.FetchBatch(keys1, 2*time.Second, genBatchDataFunc(values1, 200))
+	// the expire time is applied in milliseconds, a 1s expire ends before the sleep below
+	v, err := rc.FetchBatch(keys1, time.Second, genBatchDataFunc(values1, 200))
 	assert.Nil(t, err)
 	assert.Equal(t, values1, v)
 
-	v, err = rc.FetchBatch(keys2, 2*time.Second, genBatchDataFunc(values2, 200))
+	v, err = rc.FetchBatch(keys2, time.Second, genBatchDataFunc(values2, 200))
 	assert.Nil(t, err)
 	assert.True(t, time.Since(began) > time.Duration(150)*time.Millisecond)
 	for i := 40; i < 60; i++ {
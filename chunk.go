@@ -0,0 +1,157 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// splitChunks splits idxs into chunks of at most size indexes, idxs is not split if size <= 0
+func splitChunks(idxs []int, size int) [][]int {
+	if size <= 0 || len(idxs) <= size {
+		return [][]int{idxs}
+	}
+	chunks := make([][]int, 0, (len(idxs)+size-1)/size)
+	for len(idxs) > size {
+		chunks = append(chunks, idxs[:size:size])
+		idxs = idxs[size:]
+	}
+	return append(chunks, idxs)
+}
+
+// chunks returns the indexes of keys to call a script with, they are grouped by the hash slot in cluster mode,
+// so that a script is not called with keys in different slots, and are split into chunks of at most ScriptBatchSize keys.
+func (s *redisStore) chunks(keys []string) [][]int {
+	groups := [][]int{make([]int, len(keys))}
+	for i := range keys {
+		groups[0][i] = i
+	}
+	if s.cluster && len(keys) > 1 {
+		groups = groupBySlot(keys)
+	}
+	var chunks [][]int
+	for _, idxs := range groups {
+		chunks = append(chunks, splitChunks(idxs, s.opts.ScriptBatchSize)...)
+	}
+	return chunks
+}
+
+// callChunks calls script with the keys of each chunk and the args built for it, and calls handle with the result of each chunk.
+// the chunks are sent in one pipeline if there are more than one, so that redis is not blocked by one long script.
+// the first error is returned.
+func (s *redisStore) callChunks(ctx context.Context, script *redis.Script, keys []string, args func(idxs []int) []interface{}, handle func(idxs []int, res interface{}) error) error {
+	chunks := s.chunks(keys)
+	if len(chunks) == 1 {
+		res, err := s.call(ctx, script, pickKeys(keys, chunks[0]), args(chunks[0]))
+		if err != nil {
+			return err
+		}
+		return handle(chunks[0], res)
+	}
+	var chunkKeys = make([][]string, len(chunks))
+	var chunkArgs = make([][]interface{}, len(chunks))
+	for i, idxs := range chunks {
+		chunkKeys[i], chunkArgs[i] = pickKeys(keys, idxs), args(idxs)
+	}
+	results, errs := s.pipeline(ctx, script, chunkKeys, chunkArgs)
+	for i, idxs := range chunks {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if err := handle(idxs, results[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipeline calls script with the keys and the args of each chunk in one pipeline, and reports the latency to Options.Metrics.
+// the script is loaded and the chunks are sent again, if the script is not cached by redis.
+func (s *redisStore) pipeline(ctx context.Context, script *redis.Script, keys [][]string, args [][]interface{}) ([]interface{}, []error) {
+	began := time.Now()
+	results := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+	exec := func(chunks []int) {
+		s.opts.logger().Debug("callLua pipeline", "script", script.Hash(), "chunks", len(chunks))
+		pipe := s.rdb.Pipeline()
+		cmds := make([]*redis.Cmd, len(chunks))
+		for i, c := range chunks {
+			cmds[i] = script.EvalSha(ctx, pipe, keys[c], args[c]...)
+		}
+		_, _ = pipe.Exec(ctx) // the error of each chunk is checked below
+		for i, c := range chunks {
+			results[c], errs[c] = cmds[i].Result()
+			if errs[c] == redis.Nil {
+				errs[c] = nil
+			}
+		}
+	}
+	all := make([]int, len(keys))
+	for i := range keys {
+		all[i] = i
+	}
+	exec(all)
+	var noScript []int
+	for i, err := range errs {
+		if redis.HasErrorPrefix(err, "NOSCRIPT") {
+			noScript = append(noScript, i)
+		}
+	}
+	if len(noScript) > 0 {
+		if err := script.Load(ctx, s.rdb).Err(); err != nil {
+			s.opts.logger().Debug("callLua pipeline: load script failed", "err", err)
+		}
+		exec(noScript)
+	}
+	if s.opts.Metrics != nil {
+		for _, err := range errs {
+			s.opts.Metrics.Script(scriptNames[script], time.Since(began), err)
+		}
+	}
+	return results, errs
+}
+
+// fetchChunks fetches the values of keys[idxs] by fetchBatch, the indexes are split into chunks of at most LoaderBatchSize,
+// which are fetched by at most LoaderConcurrency calls of fn concurrently. the values of all the chunks are merged.
+// the error of the first failed chunk is returned, or the errors returned with the values are joined.
+func (c *Client) fetchChunks(ctx context.Context, keys []string, idxs []int, expire time.Duration, owner string, lockedAt time.Time, stale map[int]*staleValue, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]string, error) {
+	chunks := splitChunks(idxs, c.Options.LoaderBatchSize)
+	if len(chunks) == 1 {
+		values, err := c.fetchBatch(ctx, keys, idxs, expire, owner, lockedAt, stale, fn)
+		streamFromContext(ctx).sendFetched(ctx, keys, idxs, values)
+		return values, err
+	}
+	concurrency := c.Options.LoaderConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, concurrency)
+	var values = make([]map[int]string, len(chunks))
+	var errs = make([]error, len(chunks))
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chunk []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			values[i], errs[i] = c.fetchBatch(ctx, keys, chunk, expire, owner, lockedAt, stale, fn)
+			streamFromContext(ctx).sendFetched(ctx, keys, chunk, values[i])
+		}(i, chunk)
+	}
+	wg.Wait()
+	var result = make(map[int]string, len(idxs))
+	var returned error
+	for i := range chunks {
+		if errs[i] != nil && !valuesReturned(errs[i]) {
+			return nil, errs[i]
+		}
+		for idx, v := range values[i] {
+			result[idx] = v
+		}
+		returned = joinReturned(returned, errs[i])
+	}
+	return result, returned
+}
@@ -0,0 +1,95 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitChunks(t *testing.T) {
+	assert.Equal(t, [][]int{{0, 1, 2}}, splitChunks([]int{0, 1, 2}, 0))
+	assert.Equal(t, [][]int{{0, 1, 2}}, splitChunks([]int{0, 1, 2}, 3))
+	assert.Equal(t, [][]int{{0, 1}, {2, 3}, {4}}, splitChunks([]int{0, 1, 2, 3, 4}, 2))
+}
+
+func TestScriptBatchSize(t *testing.T) {
+	clearCache()
+	m := &countMetrics{counts: map[string]int{}}
+	opts := NewDefaultOptions()
+	opts.ScriptBatchSize = 3
+	opts.Metrics = m
+	rc := NewClient(rdb, opts)
+	n := 10
+	keys, values := genKeys(genIdxs(n)), genValues(n, "value_")
+	// the scripts are loaded again by the pipeline
+	assert.Nil(t, rdb.ScriptFlush(ctx).Err())
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values, v)
+	assert.Equal(t, 4, m.get("script:getBatch"))
+	assert.Equal(t, 4, m.get("script:setBatch"))
+
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(n, "eulav_"), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values, v)
+
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	for _, key := range keys {
+		assert.Equal(t, "0", rdb.HGet(ctx, key, "lockUntil").Val())
+	}
+
+	// the chunks are split by the hash slot too
+	rc.store.(*redisStore).cluster = true
+	rc.Options.StrongConsistency = true
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(n, "eulav_"), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(n, "eulav_"), v)
+}
+
+func TestLoaderBatchSize(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LoaderBatchSize = 4
+	opts.LoaderConcurrency = 2
+	rc := NewClient(rdb, opts)
+	n := 10
+	keys, values := genKeys(genIdxs(n)), genValues(n, "value_")
+	var mu sync.Mutex
+	var calls, running, maxRunning int
+	fn := func(ctx context.Context, idxs []int) (map[int]string, error) {
+		mu.Lock()
+		calls++
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		assert.True(t, len(idxs) <= 4)
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return genBatchDataFunc(values, 0)(idxs)
+	}
+	v, err := rc.FetchBatchCtx(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, values, v)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, maxRunning)
+
+	// a failed chunk fails the batch, the other chunks are stored
+	clearCache()
+	v, err = rc.FetchBatchCtx(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		if idxs[0] == 0 {
+			return nil, errDBDown
+		}
+		return genBatchDataFunc(values, 0)(idxs)
+	})
+	assert.Equal(t, errDBDown, err)
+	assert.Nil(t, v)
+	assert.Equal(t, "value_9", rdb.HGet(ctx, keys[9], "value").Val())
+}
@@ -2,13 +2,15 @@ package rockscache
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
-	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/lithammer/shortuuid"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -20,9 +22,15 @@ type Options struct {
 	Delay time.Duration
 	// EmptyExpire is the expire time for empty result. default is 60s
 	EmptyExpire time.Duration
+	// NotFoundExpire is the expire time for the not found result, when fn returns ErrNotFound. default is 60s
+	// if NotFoundExpire is 0, the not found result is not cached.
+	NotFoundExpire time.Duration
 	// LockExpire is the expire time for the lock which is allocated when updating cache. default is 3s
 	// should be set to the max of the underling data calculating time.
 	LockExpire time.Duration
+	// DisableLockRenew is the flag to disable renewing the lock while fetching data. default is false
+	// if not disabled, the lock is extended every LockExpire/3 until fn returns, so that it will not be taken by others.
+	DisableLockRenew bool
 	// LockSleep is the sleep interval time if try lock failed. default is 100ms
 	LockSleep time.Duration
 	// WaitReplicas is the number of replicas to wait for. default is 0
@@ -44,6 +52,77 @@ type Options struct {
 	// StrongConsistency is the flag to enable strong consistency. default is false
 	// if enabled, the Fetch result will be consistent with the db result, but performance is bad.
 	StrongConsistency bool
+	// LocalCacheSize is the max number of keys in the local memory cache, which is checked before redis. default is 0
+	// if LocalCacheSize is > 0, the local cache is enabled, and it is invalidated by TagAsDeleted from all processes.
+	// the local cache is not used in StrongConsistency mode.
+	LocalCacheSize int
+	// LocalCacheTTL is the expire time for the keys in local memory cache. default is 10s
+	// it limits the time a local value may be stale, if an invalidation message is lost.
+	LocalCacheTTL time.Duration
+	// InvalidationChannel is the redis pub/sub channel to publish the keys tag deleted. default is "rockscache:invalidation"
+	// if InvalidationChannel is empty, nothing is published, and the local cache of other processes is not invalidated.
+	InvalidationChannel string
+	// NotifyChannel is the redis pub/sub channel to publish the keys whose lock is released. default is ""
+	// if NotifyChannel is not empty, the readers waiting for the lock are woken up by the notification,
+	// and poll every LockSleep in case it is lost. every client waiting for a lock subscribes to the channel,
+	// and receives the messages of all the keys released by all processes, so it should only be enabled
+	// when the latency of waiting readers matters more than the pub/sub traffic.
+	// if NotifyChannel is empty, the readers only poll every LockSleep.
+	NotifyChannel string
+	// ScriptBatchSize is the max number of keys of a batch in one redis script call. default is 0
+	// if ScriptBatchSize is > 0, the keys of a larger batch are split into chunks, which are sent in one pipeline,
+	// so that redis is not blocked by one long script. if ScriptBatchSize is 0, the keys are not split.
+	ScriptBatchSize int
+	// LoaderBatchSize is the max number of indexes passed to fn of a batch in one call. default is 0
+	// if LoaderBatchSize is > 0, the indexes to fetch are split into chunks, and fn is called for each chunk.
+	// if LoaderBatchSize is 0, fn is called once with all the indexes to fetch.
+	LoaderBatchSize int
+	// LoaderConcurrency is the max number of concurrent calls of fn for the chunks of a batch. default is 0
+	// if LoaderConcurrency is 0, the chunks are fetched one by one.
+	LoaderConcurrency int
+	// Codec is the codec used by the typed Fetch and FetchBatch. default is JSONCodec
+	Codec Codec
+	// Metrics receives the events of the client, such as hits, misses and the latency of fn. default is nil
+	Metrics Metrics
+	// TracerProvider creates the tracer of the OpenTelemetry spans. default is nil, which uses the global provider
+	TracerProvider trace.TracerProvider
+	// AsyncRefreshTimeout is the timeout of the async refresh of a stale value, including fn and storing the result. default is 0
+	// the async refresh runs on a context with the values of the caller's context, but not canceled with it.
+	// if AsyncRefreshTimeout is 0, the async refresh is not timed out.
+	AsyncRefreshTimeout time.Duration
+	// AsyncRefreshWorkers is the max number of async refreshes running concurrently. default is 0
+	// if AsyncRefreshWorkers is 0, every async refresh runs in a new goroutine.
+	AsyncRefreshWorkers int
+	// AsyncRefreshQueue is the max number of async refreshes waiting for a worker. default is 0
+	// it is used only if AsyncRefreshWorkers > 0.
+	AsyncRefreshQueue int
+	// AsyncRefreshFullPolicy is what to do with an async refresh when the workers are busy and the queue is full.
+	// default is RefreshDrop, which returns the stale value without refreshing it.
+	AsyncRefreshFullPolicy RefreshFullPolicy
+	// OnAsyncRefreshError is called when the async refresh of a stale value fails. default is nil
+	// failures is the number of consecutive failures of key, which is reset when key is fetched successfully.
+	OnAsyncRefreshError func(key string, failures int, err error)
+	// AsyncRefreshMaxFailures is the number of consecutive async refresh failures of a key,
+	// after which the stale value is not returned, and the key is fetched synchronously so the error is returned. default is 0
+	// if AsyncRefreshMaxFailures is 0, the stale value is always returned while refreshing in background.
+	AsyncRefreshMaxFailures int
+	// CacheError reports whether the error of fn should be cached for ErrorExpire. default is nil, which caches no error
+	// the cached error is returned as a *CachedError to the callers of all processes, instead of calling fn again,
+	// so that a failing data source is not hit by every caller. the stale value is still returned in weak consistency mode,
+	// or in StaleIfError mode. the cached error is removed when the key is tag deleted.
+	CacheError func(err error) bool
+	// ErrorExpire is the expire time of the error of fn cached by CacheError. default is 1s
+	ErrorExpire time.Duration
+	// StaleIfError is the max time a tag deleted value may be returned when fn fails to fetch the new value. default is 0
+	// if StaleIfError is > 0, the tag deleted keys expire after the longer of Delay and StaleIfError,
+	// and if fn fails, the stale value is returned with a *StaleError wrapping the error of fn,
+	// if it is tag deleted less than StaleIfError ago. the stale value is kept until then, so that it is returned on the next failures.
+	// if StaleIfError is 0, the error of fn is returned.
+	StaleIfError time.Duration
+	// Logger is the structured logger of the client, such as *slog.Logger. default is nil
+	// if Logger is nil, the warnings and errors are written to the standard log package,
+	// and the debug messages are written only if SetVerbose(true) is called.
+	Logger Logger
 	// Context for redis command
 	Context context.Context
 }
@@ -53,10 +132,15 @@ func NewDefaultOptions() Options {
 	return Options{
 		Delay:                  10 * time.Second,
 		EmptyExpire:            60 * time.Second,
+		NotFoundExpire:         60 * time.Second,
+		ErrorExpire:            time.Second,
 		LockExpire:             3 * time.Second,
 		LockSleep:              100 * time.Millisecond,
 		RandomExpireAdjustment: 0.1,
 		WaitReplicasTimeout:    3000 * time.Millisecond,
+		LocalCacheTTL:          10 * time.Second,
+		InvalidationChannel:    "rockscache:invalidation",
+		Codec:                  JSONCodec{},
 		Context:                context.Background(),
 	}
 }
@@ -64,11 +148,23 @@ func NewDefaultOptions() Options {
 // Client delay client.
 type Client struct {
 	rdb     redis.UniversalClient
+	store   Store
 	Options Options
 	group   singleflight.Group
+	local   *localCache
+
+	refreshFailures failureTracker
+	refresher       refreshExecutor
+	ownedLocks      lockRegistry
+
+	notifier      *notifier
+	subscribeOnce sync.Once
+	subMu         sync.Mutex
+	pubsub        *redis.PubSub
+	subClosed     bool
 }
 
-// Rdb return the Redis client.
+// Rdb return the Redis client, it is nil if the client is created by NewClientWithStore.
 func (c *Client) Rdb() redis.UniversalClient {
 	return c.rdb
 }
@@ -77,14 +173,46 @@ func (c *Client) Rdb() redis.UniversalClient {
 // for each key, rockscache client store a hash set,
 // the hash set contains the following fields:
 // value: the value of the key
-// lockUntil: the time when the lock is released.
+// lockUntil: the time when the lock is released, in seconds.
+// lockUntilMs: the time when the lock is released, in milliseconds.
 // lockOwner: the owner of the lock.
 // if a thread query the cache for data, and no cache exists, it will lock the key before querying data in DB
 func NewClient(rdb redis.UniversalClient, options Options) *Client {
+	c := newClient(options)
+	c.rdb = rdb
+	c.store = newRedisStore(rdb, &c.Options)
+	if options.NotifyChannel != "" {
+		c.notifier = newNotifier()
+	}
+	if c.local != nil && options.InvalidationChannel != "" {
+		c.startSubscriber()
+	}
+	return c
+}
+
+// NewClientWithStore return a new rockscache client, which stores the keys in store instead of redis.
+// the invalidation and lock released messages are only published by the redis store,
+// so InvalidationChannel and NotifyChannel are not used.
+func NewClientWithStore(store Store, options Options) *Client {
+	c := newClient(options)
+	c.store = store
+	return c
+}
+
+func newClient(options Options) *Client {
 	if options.Delay == 0 || options.LockExpire == 0 {
 		panic("cache options error: Delay and LockExpire should not be 0, you should call NewDefaultOptions() to get default options")
 	}
-	return &Client{rdb: rdb, Options: options}
+	if options.Delay < time.Millisecond || options.LockExpire < time.Millisecond || options.EmptyExpire != 0 && options.EmptyExpire < time.Millisecond ||
+		options.NotFoundExpire != 0 && options.NotFoundExpire < time.Millisecond || options.StaleIfError != 0 && options.StaleIfError < time.Millisecond ||
+		options.ErrorExpire != 0 && options.ErrorExpire < time.Millisecond {
+		panic("cache options error: Delay, LockExpire, EmptyExpire, NotFoundExpire, StaleIfError and ErrorExpire should not be less than 1ms")
+	}
+	c := &Client{Options: options}
+	if options.LocalCacheSize > 0 {
+		c.local = newLocalCache(options.LocalCacheSize, options.LocalCacheTTL)
+	}
+	return c
 }
 
 // TagAsDeleted a key, the key will expire after delay time.
@@ -93,31 +221,15 @@ func (c *Client) TagAsDeleted(key string) error {
 }
 
 // TagAsDeleted2 a key, the key will expire after delay time.
-func (c *Client) TagAsDeleted2(ctx context.Context, key string) error {
+func (c *Client) TagAsDeleted2(ctx context.Context, key string) (err error) {
 	if c.Options.DisableCacheDelete {
 		return nil
 	}
-	debugf("deleting: key=%s", key)
-	luaFn := func(con redis.Scripter) error {
-		_, err := callLua(ctx, con, deleteScript, []string{key}, []interface{}{int64(c.Options.Delay / time.Second)})
-		return err
-	}
-	if c.Options.WaitReplicas > 0 {
-		err := luaFn(c.rdb)
-		cmd := redis.NewCmd(ctx, "WAIT", c.Options.WaitReplicas, c.Options.WaitReplicasTimeout)
-		if err == nil {
-			err = c.rdb.Process(ctx, cmd)
-		}
-		var replicas int
-		if err == nil {
-			replicas, err = cmd.Int()
-		}
-		if err == nil && replicas < c.Options.WaitReplicas {
-			err = fmt.Errorf("wait replicas %d failed. result replicas: %d", c.Options.WaitReplicas, replicas)
-		}
-		return err
-	}
-	return luaFn(c.rdb)
+	ctx, span := c.startSpan(ctx, "rockscache.TagAsDeleted", attribute.String("rockscache.key", key))
+	defer func() { endSpan(span, err) }()
+	c.logger().Debug("deleting", "key", key)
+	c.local.del(key)
+	return c.store.TagAsDeleted(ctx, []string{key}, c.deleteDelay())
 }
 
 // Fetch returns the value store in cache indexed by the key.
@@ -128,121 +240,218 @@ func (c *Client) Fetch(key string, expire time.Duration, fn func() (string, erro
 
 // Fetch2 returns the value store in cache indexed by the key.
 // If the key doest not exists, call fn to get result, store it in cache, then return.
+// If fn returns ErrNotFound, it is cached for NotFoundExpire, and ErrNotFound is returned.
+// If fn fails in StaleIfError mode, the stale value may be returned with a *StaleError.
 func (c *Client) Fetch2(ctx context.Context, key string, expire time.Duration, fn func() (string, error)) (string, error) {
-	ex := expire - c.Options.Delay - time.Duration(rand.Float64()*c.Options.RandomExpireAdjustment*float64(expire))
-	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+	info, err := c.fetch(ctx, key, expire, func(ctx context.Context) (string, error) {
+		return fn()
+	})
+	return info.Value, err
+}
+
+// FetchCtx is same with Fetch2, except that fn receives a context, which is canceled when ctx is done,
+// or the lock of key expires, so that fn is stopped before another owner may take the lock.
+// the lock is renewed while fn is running, unless DisableLockRenew is set, then fn has a deadline of LockExpire.
+func (c *Client) FetchCtx(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, error)) (string, error) {
+	info, err := c.fetch(ctx, key, expire, fn)
+	return info.Value, err
+}
+
+func (c *Client) fetch(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, error)) (info FetchInfo, err error) {
+	ctx, span, stats := c.startFetchSpan(ctx, "rockscache.Fetch", attribute.String("rockscache.key", key))
+	defer func() { endFetchSpan(span, stats, false, err) }()
+	if !c.Options.DisableCacheRead && !c.Options.StrongConsistency {
+		if v, ok := c.local.get(key); ok {
+			c.logger().Debug("local cache hit", "key", key)
+			c.hit(ctx, key)
+			return notFoundInfo(FetchInfo{Value: v, Outcome: OutcomeHit, Local: true}, nil)
+		}
+	}
+	v, err, shared := c.group.Do(key, func() (interface{}, error) {
+		ctx, rec := withInfoRecorder(ctx)
+		var v string
+		var err error
 		if c.Options.DisableCacheRead {
-			return fn()
+			rec.outcome(key, OutcomeMiss)
+			if v, err = fn(ctx); errors.Is(err, ErrNotFound) {
+				v, err = notFoundValue, nil
+			}
 		} else if c.Options.StrongConsistency {
-			return c.strongFetch(ctx, key, ex, fn)
+			v, err = c.strongFetch(ctx, key, expire, fn)
+		} else {
+			v, err = c.weakFetch(ctx, key, expire, fn)
 		}
-		return c.weakFetch(ctx, key, ex, fn)
+		return rec.get(key, v), err
 	})
-	return v.(string), err
+	// the outcome of a shared call is recorded in the span of the caller which runs it
+	span.SetAttributes(attribute.Bool("rockscache.shared", shared))
+	info = v.(FetchInfo)
+	info.Shared = shared
+	return notFoundInfo(info, err)
 }
 
-func (c *Client) luaGet(ctx context.Context, key string, owner string) ([]interface{}, error) {
-	res, err := callLua(ctx, c.rdb, getScript, []string{key}, []interface{}{now(), now() + int64(c.Options.LockExpire/time.Second), owner})
-	debugf("luaGet return: %v, %v", res, err)
+func (c *Client) get(ctx context.Context, key string, owner string) ([]interface{}, error) {
+	ctx, span := c.startSpan(ctx, "rockscache.get", attribute.String("rockscache.key", key), attribute.String("rockscache.owner", owner))
+	rs, err := c.store.Get(ctx, []string{key}, c.Options.LockExpire, owner)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
-	return res.([]interface{}), nil
+	r := rs[0].([]interface{})
+	infoFromContext(ctx).got(key, r)
+	return r, nil
 }
 
-func (c *Client) luaSet(ctx context.Context, key string, value string, expire int, owner string) error {
-	_, err := callLua(ctx, c.rdb, setScript, []string{key}, []interface{}{value, owner, expire})
+func (c *Client) set(ctx context.Context, key string, value string, expire time.Duration, owner string) error {
+	lost, err := c.store.Set(ctx, []string{key}, []string{value}, []time.Duration{expire}, owner)
+	if err == nil && len(lost) > 0 {
+		return lockLostError(lost, owner)
+	}
 	return err
 }
 
-func (c *Client) fetchNew(ctx context.Context, key string, expire time.Duration, owner string, fn func() (string, error)) (string, error) {
-	result, err := fn()
+// fetchNew fetches the value of key locked by owner, and stores it for expire, or the ttl returned by the loader of FetchWithTTL.
+// if fn fails, the stale value is returned with a StaleError if it may be returned, otherwise the error of fn.
+func (c *Client) fetchNew(ctx context.Context, key string, expire time.Duration, owner string, lockedAt time.Time, stale *staleValue, fn func(ctx context.Context) (string, error)) (result string, err error) {
+	ctx, span := c.startSpan(ctx, "rockscache.fetchNew", attribute.String("rockscache.key", key), attribute.String("rockscache.owner", owner))
+	defer func() { endSpan(span, err) }()
+	fnCtx, ttls := withTTLRecorder(ctx)
+	keeper := c.keepLock(fnCtx, []string{key}, owner, lockedAt)
+	defer keeper.stop()
+	began := time.Now()
+	result, err = fn(keeper.ctx)
+	if errors.Is(err, ErrNotFound) {
+		result, err = notFoundValue, nil
+	}
+	c.metrics().Fetched([]string{key}, time.Since(began), err)
 	if err != nil {
-		_ = c.UnlockForUpdate(ctx, key, owner)
-		return "", err
+		return c.unlockStale(ctx, key, owner, stale, err)
 	}
-	if result == "" {
+	c.refreshFailures.reset(key)
+	if result == notFoundValue {
+		if c.Options.NotFoundExpire == 0 { // if not found expire is 0, then delete the key
+			err = c.store.Del(ctx, key)
+			infoFromContext(ctx).stored(key, 0, false)
+			return result, err
+		}
+		expire = c.Options.NotFoundExpire
+	} else if result == "" {
 		if c.Options.EmptyExpire == 0 { // if empty expire is 0, then delete the key
-			err = c.rdb.Del(ctx, key).Err()
+			err = c.store.Del(ctx, key)
+			infoFromContext(ctx).stored(key, 0, false)
 			return "", err
 		}
+		c.metrics().EmptyCached(key)
 		expire = c.Options.EmptyExpire
+	} else {
+		expire = c.expireFor(ttls.expire(0, expire))
+	}
+	err = c.set(ctx, key, result, expire, owner)
+	if err == nil {
+		infoFromContext(ctx).stored(key, expire, result == "")
 	}
-	err = c.luaSet(ctx, key, result, int(expire/time.Second), owner)
 	return result, err
 }
 
-func (c *Client) weakFetch(ctx context.Context, key string, expire time.Duration, fn func() (string, error)) (string, error) {
-	debugf("weakFetch: key=%s", key)
+func (c *Client) weakFetch(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, error)) (string, error) {
+	c.logger().Debug("weakFetch", "key", key)
+	version := c.local.currentVersion()
 	owner := shortuuid.New()
-	r, err := c.luaGet(ctx, key, owner)
-	for err == nil && r[0] == nil && r[1].(string) != locked {
-		debugf("empty result for %s locked by other, so sleep %s", key, c.Options.LockSleep.String())
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		case <-time.After(c.Options.LockSleep):
-			// equal to time.Sleep(c.Options.LockSleep) but can be canceled
+	w := c.newLockWaiter(key)
+	defer w.stop()
+	lockedAt := time.Now()
+	r, err := c.get(ctx, key, owner)
+	for err == nil && r[0] == nil && r[1].(string) != locked && cachedError(r) == nil {
+		c.logger().Debug("empty result locked by other, so sleep", "key", key, "sleep", c.Options.LockSleep)
+		if err := w.wait(ctx); err != nil {
+			return "", err
 		}
-		r, err = c.luaGet(ctx, key, owner)
+		lockedAt = time.Now()
+		r, err = c.get(ctx, key, owner)
 	}
 	if err != nil {
 		return "", err
 	}
-	if r[1] != locked {
+	if r[0] == nil && r[1] != locked { // the error of fn is cached by other
+		return "", cachedError(r)
+	}
+	if r[1] == nil { // the value is not being refreshed
+		c.hit(ctx, key)
+		c.local.set(key, r[0].(string), version)
+		return r[0].(string), nil
+	}
+	if r[1] != locked { // the stale value, which is being refreshed by other
+		c.staleServed(ctx, key)
 		return r[0].(string), nil
 	}
-	if r[0] == nil {
-		return c.fetchNew(ctx, key, expire, owner, fn)
+	stale := c.staleOf(r)
+	if r[0] == nil || c.refreshFailing(key) || len(c.submitRefresh([]string{key}, []int{0}, owner, func([]int) {
+		ctx, cancel := c.asyncContext(ctx)
+		defer cancel()
+		ctx, span := c.startAsyncSpan(ctx, "rockscache.asyncRefresh", attribute.String("rockscache.key", key))
+		_, err := c.fetchNew(ctx, key, expire, owner, lockedAt, stale, fn)
+		endSpan(span, err)
+		if err != nil {
+			c.asyncRefreshFailed([]string{key}, owner, err)
+		}
+	})) > 0 {
+		c.miss(ctx, key)
+		v, err := c.fetchNew(ctx, key, expire, owner, lockedAt, stale, fn)
+		if err == nil {
+			c.setLocalFetched(key, v, version)
+		}
+		return v, err
 	}
-	go withRecover(func() {
-		_, _ = c.fetchNew(ctx, key, expire, owner, fn)
-	})
+	c.staleServed(ctx, key)
 	return r[0].(string), nil
 }
 
-func (c *Client) strongFetch(ctx context.Context, key string, expire time.Duration, fn func() (string, error)) (string, error) {
-	debugf("strongFetch: key=%s", key)
+func (c *Client) strongFetch(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, error)) (string, error) {
+	c.logger().Debug("strongFetch", "key", key)
 	owner := shortuuid.New()
-	r, err := c.luaGet(ctx, key, owner)
-	for err == nil && r[1] != nil && r[1] != locked { // locked by other
-		debugf("locked by other, so sleep %s", c.Options.LockSleep)
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		case <-time.After(c.Options.LockSleep):
-			// equal to time.Sleep(c.Options.LockSleep) but can be canceled
+	w := c.newLockWaiter(key)
+	defer w.stop()
+	lockedAt := time.Now()
+	r, err := c.get(ctx, key, owner)
+	for err == nil && r[1] != nil && r[1] != locked && cachedError(r) == nil { // locked by other
+		c.logger().Debug("locked by other, so sleep", "key", key, "sleep", c.Options.LockSleep)
+		if err := w.wait(ctx); err != nil {
+			return "", err
 		}
-		r, err = c.luaGet(ctx, key, owner)
+		lockedAt = time.Now()
+		r, err = c.get(ctx, key, owner)
 	}
 	if err != nil {
 		return "", err
 	}
+	if cerr := cachedError(r); cerr != nil { // the error of fn is cached by other
+		return c.serveCachedError(ctx, key, c.staleOf(r), cerr)
+	}
 	if r[1] != locked { // normal value
+		c.hit(ctx, key)
 		return r[0].(string), nil
 	}
-	return c.fetchNew(ctx, key, expire, owner, fn)
+	c.miss(ctx, key)
+	return c.fetchNew(ctx, key, expire, owner, lockedAt, c.staleOf(r), fn)
 }
 
 // RawGet returns the value store in cache indexed by the key, no matter if the key locked or not
 func (c *Client) RawGet(ctx context.Context, key string) (string, error) {
-	return c.rdb.HGet(ctx, key, "value").Result()
+	return c.store.RawGet(ctx, key)
 }
 
 // RawSet sets the value store in cache indexed by the key, no matter if the key locked or not
 func (c *Client) RawSet(ctx context.Context, key string, value string, expire time.Duration) error {
-	err := c.rdb.HSet(ctx, key, "value", value).Err()
-	if err == nil {
-		err = c.rdb.Expire(ctx, key, expire).Err()
-	}
-	return err
+	c.local.del(key)
+	return c.store.RawSet(ctx, key, value, expire)
 }
 
 // LockForUpdate locks the key, used in very strict strong consistency mode
-func (c *Client) LockForUpdate(ctx context.Context, key string, owner string) error {
-	lockUntil := math.Pow10(10)
-	res, err := callLua(ctx, c.rdb, lockScript, []string{key}, []interface{}{owner, lockUntil})
-	if err == nil && res != "LOCKED" {
+func (c *Client) LockForUpdate(ctx context.Context, key string, owner string) (err error) {
+	ctx, span := c.startSpan(ctx, "rockscache.LockForUpdate", attribute.String("rockscache.key", key), attribute.String("rockscache.owner", owner))
+	defer func() { endSpan(span, err) }()
+	res, err := c.store.Lock(ctx, key, owner)
+	if err == nil && res != locked {
 		return fmt.Errorf("%s has been locked by %s", key, res)
 	}
 	return err
@@ -250,6 +459,5 @@ func (c *Client) LockForUpdate(ctx context.Context, key string, owner string) er
 
 // UnlockForUpdate unlocks the key, used in very strict strong consistency mode
 func (c *Client) UnlockForUpdate(ctx context.Context, key string, owner string) error {
-	_, err := callLua(ctx, c.rdb, unlockScript, []string{key}, []interface{}{owner, c.Options.LockExpire / time.Second})
-	return err
+	return c.store.Unlock(ctx, key, owner, c.Options.LockExpire)
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,6 +17,14 @@ func TestBadOptions(t *testing.T) {
 	})
 }
 
+func TestSubMillisecondOptions(t *testing.T) {
+	opts := NewDefaultOptions()
+	opts.EmptyExpire = time.Microsecond
+	assert.Panics(t, func() {
+		NewClient(nil, opts)
+	})
+}
+
 func TestDisable(t *testing.T) {
 	rc := NewClient(nil, NewDefaultOptions())
 	rc.Options.DisableCacheDelete = true
@@ -98,3 +107,68 @@ func TestTagAsDeletedWait(t *testing.T) {
 		assert.Error(t, err, fmt.Errorf("wait replicas 1 failed. result replicas: 0"))
 	}
 }
+
+func TestSubSecondLockExpire(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LockExpire = 200 * time.Millisecond
+	opts.DisableLockRenew = true
+	rc := NewClient(rdb, opts)
+	rc.Options.StrongConsistency = true
+	go func() {
+		// the lock expired after 200ms, and the value stored by dc2 is kept
+		_, err := rc.Fetch("key1", 60*time.Second, genDataFunc("value1", 500))
+		assert.Nil(t, err)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	began := time.Now()
+	dc2 := NewClient(rdb, opts)
+	dc2.Options.StrongConsistency = true
+	v, err := dc2.Fetch2(ctx, "key1", 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+	assert.True(t, time.Since(began) < 100*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	v, err = rc.RawGet(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestSubSecondDelay(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.Delay = 100 * time.Millisecond
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch("key1", 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted("key1")
+	assert.Nil(t, err)
+	_, err = rc.RawGet(ctx, "key1")
+	assert.Nil(t, err)
+	time.Sleep(200 * time.Millisecond)
+	_, err = rc.RawGet(ctx, "key1")
+	assert.ErrorIs(t, err, redis.Nil)
+}
+
+func TestLockUntilOfOldVersion(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	// a lock taken by older versions, in seconds
+	err := rdb.HSet(ctx, "key1", "lockUntil", time.Now().Add(10*time.Second).Unix(), "lockOwner", "old").Err()
+	assert.Nil(t, err)
+	r, err := rc.get(ctx, "key1", "new")
+	assert.Nil(t, err)
+	assert.NotEqual(t, locked, r[1])
+
+	// a lock renewed by older versions after lockUntilMs is written
+	err = rdb.HSet(ctx, "key1", "lockUntil", time.Now().Add(-time.Second).Unix(), "lockUntilMs", time.Now().Add(10*time.Second).UnixMilli()).Err()
+	assert.Nil(t, err)
+	r, err = rc.get(ctx, "key1", "new")
+	assert.Nil(t, err)
+	assert.Equal(t, locked, r[1])
+
+	// older versions read the lock in seconds
+	lu, err := rdb.HGet(ctx, "key1", "lockUntil").Int64()
+	assert.Nil(t, err)
+	assert.True(t, lu >= time.Now().Add(rc.Options.LockExpire).Unix())
+}
@@ -164,7 +164,9 @@ func TestStrongFetchCanceled(t *testing.T) {
 	rc := NewClient(rdb, NewDefaultOptions())
 	rc.Options.StrongConsistency = true
 	expected := "value1"
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		dc2 := NewClient(rdb, NewDefaultOptions())
 		v, err := dc2.Fetch(rdbKey, 60*time.Second, genDataFunc(expected, 450))
 		assert.Nil(t, err)
@@ -188,6 +190,7 @@ func TestStrongFetchCanceled(t *testing.T) {
 	_, err = rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc(expected, 200))
 	assert.ErrorIs(t, err, context.Canceled)
 	assertEqualDuration(t, time.Duration(200)*time.Millisecond, time.Since(began))
+	<-done // the lock of dc2 should not be taken by the next test
 }
 
 func TestWeakErrorFetch(t *testing.T) {
@@ -213,7 +216,9 @@ func TestWeakFetchCanceled(t *testing.T) {
 
 	clearCache()
 	expected := "value1"
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		dc2 := NewClient(rdb, NewDefaultOptions())
 		v, err := dc2.Fetch(rdbKey, 60*time.Second, genDataFunc(expected, 450))
 		assert.Nil(t, err)
@@ -237,6 +242,7 @@ func TestWeakFetchCanceled(t *testing.T) {
 	_, err = rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc(expected, 200))
 	assert.ErrorIs(t, err, context.Canceled)
 	assertEqualDuration(t, time.Duration(200)*time.Millisecond, time.Since(began))
+	<-done // the lock of dc2 should not be taken by the next test
 }
 
 func TestRawGet(t *testing.T) {
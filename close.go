@@ -0,0 +1,89 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+)
+
+type lockRef struct {
+	key   string
+	owner string
+}
+
+// lockRegistry counts the locks held by the fetches and the async refreshes of the client,
+// so that they are released by Close.
+type lockRegistry struct {
+	mu    sync.Mutex
+	locks map[lockRef]int
+}
+
+func (r *lockRegistry) add(keys []string, owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locks == nil {
+		r.locks = make(map[lockRef]int)
+	}
+	for _, key := range keys {
+		r.locks[lockRef{key, owner}]++
+	}
+}
+
+func (r *lockRegistry) remove(keys []string, owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		ref := lockRef{key, owner}
+		if r.locks[ref] <= 1 {
+			delete(r.locks, ref)
+		} else {
+			r.locks[ref]--
+		}
+	}
+}
+
+func (r *lockRegistry) all() []lockRef {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	refs := make([]lockRef, 0, len(r.locks))
+	for ref := range r.locks {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// Close stops the async refreshes, the stale values are fetched synchronously after closed.
+// it waits for the async refreshes started before until ctx is done, then releases the locks
+// still held by the fetches and the async refreshes of the client, so that others do not wait for them until LockExpire.
+// the locks of the refreshes skipped or dropped by the queue are not held, they still expire after LockExpire.
+// the error of ctx is returned if the async refreshes are not finished, otherwise the first error of releasing the locks.
+func (c *Client) Close(ctx context.Context) error {
+	e := &c.refresher
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// the locks are released even if ctx is done
+	uctx := detachedContext{parent: ctx}
+	for _, ref := range c.ownedLocks.all() {
+		c.logger().Debug("release lock on close", "key", ref.key, "owner", ref.owner)
+		if uerr := c.UnlockForUpdate(uctx, ref.key, ref.owner); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	if serr := c.closeSubscriber(); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStaleClient(t *testing.T, opts Options) *Client {
+	clearCache()
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	return rc
+}
+
+func TestCloseWaitsRefresh(t *testing.T) {
+	rc := newStaleClient(t, NewDefaultOptions())
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 50))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	err = rc.Close(context.Background())
+	assert.Nil(t, err)
+	s, err := rc.RawGet(ctx, rdbKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", s)
+}
+
+func TestCloseReleasesLocks(t *testing.T) {
+	rc := newStaleClient(t, NewDefaultOptions())
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 300))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	cctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = rc.Close(cctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the lock of the refresh is released, so the strong reader does not wait for it
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	rc2 := NewClient(rdb, opts)
+	began := time.Now()
+	v, err = rc2.Fetch(rdbKey, 60*time.Second, genDataFunc("value3", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value3", v)
+	assert.True(t, time.Since(began) < 100*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+}
+
+func TestFetchAfterClose(t *testing.T) {
+	rc := newStaleClient(t, NewDefaultOptions())
+	err := rc.Close(context.Background())
+	assert.Nil(t, err)
+	// the stale value is fetched synchronously after closed
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
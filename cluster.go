@@ -0,0 +1,59 @@
+package rockscache
+
+import (
+	"strings"
+)
+
+// clusterSlots is the number of hash slots in redis cluster
+const clusterSlots = 16384
+
+// keySlot returns the redis cluster hash slot of key, only the hash tag is hashed if the key has one
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) used by redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// groupBySlot groups the indexes of keys by the hash slot, in the order of the first key of each slot
+func groupBySlot(keys []string) [][]int {
+	var groups [][]int
+	var slotGroup = make(map[int]int)
+	for i, key := range keys {
+		slot := keySlot(key)
+		g, ok := slotGroup[slot]
+		if !ok {
+			g = len(groups)
+			slotGroup[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+func pickKeys(keys []string, idxs []int) []string {
+	picked := make([]string, len(idxs))
+	for i, idx := range idxs {
+		picked[i] = keys[idx]
+	}
+	return picked
+}
@@ -0,0 +1,45 @@
+package rockscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, 12739, keySlot("123456789"))
+	assert.Equal(t, 12182, keySlot("foo"))
+	assert.Equal(t, keySlot("user1000"), keySlot("{user1000}.following"))
+	assert.Equal(t, keySlot("{user1000}.following"), keySlot("{user1000}.followers"))
+	assert.Equal(t, keySlot("foo{}{bar}"), int(crc16("foo{}{bar}")%clusterSlots))
+}
+
+func TestGroupBySlot(t *testing.T) {
+	keys := []string{"{a}1", "{b}1", "{a}2", "{c}1", "{b}2"}
+	assert.Equal(t, [][]int{{0, 2}, {1, 4}, {3}}, groupBySlot(keys))
+}
+
+func TestClusterFetchBatch(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	// a single node accepts the keys in any slot, the results of all the slots should be merged
+	rc.store.(*redisStore).cluster = true
+	n := 20
+	keys, values1, values2 := genKeys(genIdxs(n)), genValues(n, "value_"), genValues(n, "eulav_")
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values2, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+
+	time.Sleep(50 * time.Millisecond)
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values2, v)
+	assert.True(t, len(groupBySlot(keys)) > 1)
+}
@@ -0,0 +1,131 @@
+package rockscache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes the values cached by the typed Fetch and FetchBatch
+type Codec interface {
+	// Marshal encodes v to the bytes stored in cache
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, v is a pointer
+	Unmarshal(data []byte, v interface{}) error
+	// IsEmpty reports whether v is an empty result, which will be cached with EmptyExpire
+	IsEmpty(v interface{}) bool
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec struct{}
+
+// Marshal implements Codec
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// IsEmpty implements Codec
+func (JSONCodec) IsEmpty(v interface{}) bool {
+	return isEmptyValue(v)
+}
+
+// GobCodec encodes values with encoding/gob
+type GobCodec struct{}
+
+// Marshal implements Codec
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// Unmarshal implements Codec
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// IsEmpty implements Codec
+func (GobCodec) IsEmpty(v interface{}) bool {
+	return isEmptyValue(v)
+}
+
+// MsgpackCodec encodes values with msgpack
+type MsgpackCodec struct{}
+
+// Marshal implements Codec
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal implements Codec
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// IsEmpty implements Codec
+func (MsgpackCodec) IsEmpty(v interface{}) bool {
+	return isEmptyValue(v)
+}
+
+// ProtobufCodec encodes values with protobuf, the values should implement proto.Message
+type ProtobufCodec struct{}
+
+// Marshal implements Codec
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements Codec
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	// v is a pointer to a message pointer, such as **pb.User, allocate the message first
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		m := reflect.New(rv.Elem().Type().Elem())
+		if pm, ok := m.Interface().(proto.Message); ok {
+			if err := proto.Unmarshal(data, pm); err != nil {
+				return err
+			}
+			rv.Elem().Set(m)
+			return nil
+		}
+	}
+	return fmt.Errorf("protobuf codec: %T is not a pointer to proto.Message", v)
+}
+
+// IsEmpty implements Codec
+func (ProtobufCodec) IsEmpty(v interface{}) bool {
+	return isEmptyValue(v)
+}
+
+// isEmptyValue treats nil and empty string as empty result
+func isEmptyValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
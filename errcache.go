@@ -0,0 +1,67 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCached is matched by the error of fn cached by another fetch, see Options.CacheError
+var ErrCached = errors.New("cached error")
+
+// CachedError is the error of fn cached by another fetch, which is returned instead of calling fn until ErrorExpire.
+// only the message of the error is kept, it matches ErrCached.
+type CachedError struct {
+	Message string
+}
+
+func (e *CachedError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrCached
+func (e *CachedError) Is(target error) bool {
+	return target == ErrCached
+}
+
+// cachedError returns the error cached in the result r of Store.Get, or nil if not cached
+func cachedError(r []interface{}) error {
+	if len(r) > 4 && r[4] != nil {
+		return &CachedError{Message: r[4].(string)}
+	}
+	return nil
+}
+
+// unlockFailed releases the locks of keys after fn fails with err, the keys expire after keeps if > 0, otherwise LockExpire.
+// err is cached for ErrorExpire if CacheError returns true for it.
+func (c *Client) unlockFailed(ctx context.Context, keys []string, keeps []time.Duration, owner string, err error) {
+	expires := make([]time.Duration, len(keys))
+	for i := range keys {
+		expires[i] = keeps[i]
+		if expires[i] <= 0 {
+			expires[i] = c.Options.LockExpire
+		}
+	}
+	if c.Options.CacheError != nil && c.Options.ErrorExpire > 0 && c.Options.CacheError(err) {
+		c.logger().Debug("cache error", "keys", keys, "err", err)
+		if cerr := c.store.CacheError(ctx, keys, expires, owner, err.Error(), c.Options.ErrorExpire); cerr != nil {
+			c.logger().Warn("cache error failed", "keys", keys, "owner", owner, "err", cerr)
+		}
+		return
+	}
+	for i, key := range keys {
+		_ = c.store.Unlock(ctx, key, owner, expires[i])
+	}
+}
+
+// serveCachedError returns the error of fn cached by another fetch,
+// or the stale value with a StaleError if it may be returned in StaleIfError mode.
+func (c *Client) serveCachedError(ctx context.Context, key string, stale *staleValue, err error) (string, error) {
+	if c.keepFor(stale) <= 0 {
+		return "", err
+	}
+	age := time.Since(stale.staleAt)
+	infoFromContext(ctx).outcome(key, OutcomeStale)
+	c.metrics().StaleIfError(key, age)
+	return stale.value, &StaleError{Err: err, Age: age}
+}
@@ -0,0 +1,113 @@
+package rockscache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cacheDBDown(err error) bool { return errors.Is(err, errDBDown) }
+
+func TestCacheError(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.CacheError = cacheDBDown
+	opts.ErrorExpire = 100 * time.Millisecond
+	rc := NewClient(rdb, opts)
+	other := NewClient(rdb, opts)
+
+	_, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+	// the error is returned to the other process without calling fn
+	_, err = other.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.ErrorIs(t, err, ErrCached)
+	assert.Equal(t, errDBDown.Error(), err.Error())
+	_, err = rc.FetchBatch([]string{rdbKey}, 60*time.Second, genBatchDataFunc(map[int]string{0: "ignored"}, 0))
+	assert.ErrorIs(t, err, ErrCached)
+
+	time.Sleep(110 * time.Millisecond)
+	v, err := other.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	assert.False(t, rdb.HExists(ctx, rdbKey, "error").Val())
+}
+
+func TestCacheErrorNotMatched(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.CacheError = func(err error) bool { return false }
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+}
+
+func TestCacheErrorTagDeleted(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.CacheError = cacheDBDown
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+}
+
+func TestCacheErrorKeepsStale(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.CacheError = cacheDBDown
+	opts.StaleIfError = 10 * time.Second
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+
+	// weak consistency serves the stale value while the error is cached
+	rc.Options.StrongConsistency = true
+	v, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.ErrorIs(t, err, ErrStale)
+	assert.Equal(t, "value1", v)
+	rc.Options.StrongConsistency = false
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	// strong consistency serves the stale value with the cached error in StaleIfError mode
+	rc.Options.StrongConsistency = true
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.ErrorIs(t, err, ErrStale)
+	assert.ErrorIs(t, err, ErrCached)
+	assert.Equal(t, "value1", v)
+
+	keys := []string{rdbKey, "key_no_error"}
+	vs, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(map[int]string{0: "ignored", 1: "value2"}, 0))
+	assert.ErrorIs(t, err, ErrCached)
+	var se *StaleError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, []int{0}, se.Idxs)
+	assert.Equal(t, map[int]string{0: "value1", 1: "value2"}, vs)
+}
+
+func TestCacheErrorMemory(t *testing.T) {
+	rc := newMemoryClient()
+	rc.Options.CacheError = cacheDBDown
+	_, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+	_, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.ErrorIs(t, err, ErrCached)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+}
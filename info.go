@@ -0,0 +1,147 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Outcome is how the value of a key is got by FetchWithInfo
+type Outcome int
+
+const (
+	// OutcomeNone means the key is neither read from cache nor fetched by fn, such as when redis fails
+	OutcomeNone Outcome = iota
+	// OutcomeHit means the value is returned from cache
+	OutcomeHit
+	// OutcomeMiss means the value is not in cache, and is fetched by fn synchronously
+	OutcomeMiss
+	// OutcomeStale means the stale value is returned, while it is being refreshed,
+	// or fn fails in StaleIfError mode
+	OutcomeStale
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeHit:
+		return "hit"
+	case OutcomeMiss:
+		return "miss"
+	case OutcomeStale:
+		return "stale"
+	}
+	return "none"
+}
+
+// FetchInfo is the value of a key returned by FetchWithInfo, and how it is got
+type FetchInfo struct {
+	Value   string
+	Outcome Outcome
+	// Local is true if the value is returned from the local cache
+	Local bool
+	// Shared is true if the value is also returned to other concurrent calls of the same key in this process
+	Shared bool
+	// LockWait is the time spent waiting for the lock of the key held by others
+	LockWait time.Duration
+	// TTL is the remaining time to live of the key in cache when it is read or stored,
+	// it is 0 if unknown, such as the value is from the local cache, or not stored
+	TTL time.Duration
+	// Empty is true if the value is empty, and cached with EmptyExpire
+	Empty bool
+	// NotFound is true if fn returned ErrNotFound for the key, and it is cached with NotFoundExpire
+	NotFound bool
+}
+
+// infoRecorder collects the FetchInfo of the keys fetched with the context
+type infoRecorder struct {
+	mu    sync.Mutex
+	infos map[string]*FetchInfo
+}
+
+type infoRecorderKey struct{}
+
+func withInfoRecorder(ctx context.Context) (context.Context, *infoRecorder) {
+	rec := &infoRecorder{infos: make(map[string]*FetchInfo)}
+	return context.WithValue(ctx, infoRecorderKey{}, rec), rec
+}
+
+func infoFromContext(ctx context.Context) *infoRecorder {
+	rec, _ := ctx.Value(infoRecorderKey{}).(*infoRecorder)
+	return rec
+}
+
+// update calls f with the info of key, if r is not nil
+func (r *infoRecorder) update(key string, f func(info *FetchInfo)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := r.infos[key]
+	if info == nil {
+		info = &FetchInfo{}
+		r.infos[key] = info
+	}
+	f(info)
+}
+
+func (r *infoRecorder) outcome(key string, outcome Outcome) {
+	r.update(key, func(info *FetchInfo) { info.Outcome = outcome })
+}
+
+func (r *infoRecorder) localHit(key string) {
+	r.update(key, func(info *FetchInfo) { info.Outcome, info.Local, info.TTL = OutcomeHit, true, 0 })
+}
+
+func (r *infoRecorder) lockWait(key string, d time.Duration) {
+	r.update(key, func(info *FetchInfo) { info.LockWait += d })
+}
+
+// got records the result of Store.Get for key
+func (r *infoRecorder) got(key string, res []interface{}) {
+	r.update(key, func(info *FetchInfo) {
+		info.TTL, info.Empty = 0, res[0] == ""
+		if len(res) > 3 {
+			if ms, ok := res[3].(int64); ok && ms > 0 {
+				info.TTL = time.Duration(ms) * time.Millisecond
+			}
+		}
+	})
+}
+
+// stored records the value of key stored for ttl, ttl is 0 if the value is not stored
+func (r *infoRecorder) stored(key string, ttl time.Duration, empty bool) {
+	r.update(key, func(info *FetchInfo) { info.TTL, info.Empty = ttl, empty })
+}
+
+// get returns the info of key with value
+func (r *infoRecorder) get(key string, value string) FetchInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var info FetchInfo
+	if p := r.infos[key]; p != nil {
+		info = *p
+	}
+	info.Value = value
+	return info
+}
+
+// FetchWithInfo is same with FetchCtx, except that it returns how the value is got, such as from cache or fn.
+func (c *Client) FetchWithInfo(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, error)) (FetchInfo, error) {
+	return c.fetch(ctx, key, expire, fn)
+}
+
+// FetchBatchWithInfo is same with FetchBatchCtx, except that it returns how the value of each index is got.
+// the not found indexes are in the result, with NotFound set.
+func (c *Client) FetchBatchWithInfo(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]FetchInfo, error) {
+	ctx, rec := withInfoRecorder(ctx)
+	values, err := c.fetchKeys(ctx, keys, expire, fn)
+	if values == nil {
+		return nil, err
+	}
+	infos := make(map[int]FetchInfo, len(values))
+	for idx, v := range values {
+		infos[idx], _ = notFoundInfo(rec.get(keys[idx], v), nil)
+	}
+	return infos, err
+}
@@ -0,0 +1,90 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ctxData(value string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) { return value, nil }
+}
+
+func TestFetchWithInfo(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LocalCacheSize = 10
+	rc := NewClient(rdb, opts)
+	defer rc.Close(ctx)
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+
+	info, err := rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("value1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", info.Value)
+	assert.Equal(t, OutcomeMiss, info.Outcome)
+	assert.False(t, info.Empty)
+	// the expire is reduced by Delay and RandomExpireAdjustment
+	assert.True(t, info.TTL > 40*time.Second && info.TTL <= 50*time.Second, info.TTL)
+
+	info, err = rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("ignored"))
+	assert.Nil(t, err)
+	assert.Equal(t, FetchInfo{Value: "value1", Outcome: OutcomeHit, Local: true}, info)
+
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	info, err = rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("value2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", info.Value)
+	assert.Equal(t, OutcomeStale, info.Outcome)
+	assert.True(t, info.TTL > 0 && info.TTL <= opts.Delay, info.TTL)
+
+	info, err = rc.FetchWithInfo(ctx, "empty-key", 60*time.Second, ctxData(""))
+	assert.Nil(t, err)
+	assert.Equal(t, OutcomeMiss, info.Outcome)
+	assert.True(t, info.Empty)
+	assert.Equal(t, opts.EmptyExpire, info.TTL)
+}
+
+func TestFetchWithInfoLockWait(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	go func() {
+		dc := NewClient(rdb, NewDefaultOptions())
+		_, _ = dc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 150))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	info, err := rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("ignored"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", info.Value)
+	assert.Equal(t, OutcomeHit, info.Outcome)
+	assert.True(t, info.LockWait >= 100*time.Millisecond, info.LockWait)
+	assert.True(t, info.TTL > 0)
+}
+
+func TestFetchBatchWithInfo(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(3))
+	values := genValues(3, "value_")
+	fn := func(ctx context.Context, idxs []int) (map[int]string, error) {
+		return genBatchDataFunc(values, 0)(idxs)
+	}
+	infos, err := rc.FetchBatchWithInfo(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	for i := range keys {
+		assert.Equal(t, values[i], infos[i].Value)
+		assert.Equal(t, OutcomeMiss, infos[i].Outcome)
+		assert.True(t, infos[i].TTL > 40*time.Second)
+	}
+
+	err = rc.TagAsDeleted(keys[1])
+	assert.Nil(t, err)
+	infos, err = rc.FetchBatchWithInfo(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, OutcomeHit, infos[0].Outcome)
+	assert.Equal(t, OutcomeStale, infos[1].Outcome)
+	assert.Equal(t, OutcomeHit, infos[2].Outcome)
+	assert.Equal(t, "value_1", infos[1].Value)
+}
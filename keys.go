@@ -0,0 +1,91 @@
+package rockscache
+
+import (
+	"context"
+	"time"
+)
+
+// dedupKeys returns the unique keys in keys, and the index of the first occurrence of each unique key in keys
+func dedupKeys(keys []string) (uniq []string, firstIdxs []int) {
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		uniq = append(uniq, key)
+		firstIdxs = append(firstIdxs, i)
+	}
+	return uniq, firstIdxs
+}
+
+// FetchBatchKeys is same with FetchBatchCtx, except that fn receives the missing keys instead of their indexes,
+// and the values are keyed by the keys. the duplicate keys are fetched once.
+// if the stale values are returned with a *StaleError, its Idxs are the indexes of the first occurrences of the stale keys in keys.
+func (c *Client) FetchBatchKeys(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, keys []string) (map[string]string, error)) (map[string]string, error) {
+	uniq, firstIdxs := dedupKeys(keys)
+	result, err := c.fetchKeys(ctx, uniq, expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		data, err := fn(ctx, pickKeys(uniq, idxs))
+		if data == nil {
+			return nil, err
+		}
+		values := make(map[int]string, len(data))
+		for _, idx := range idxs {
+			if v, ok := data[uniq[idx]]; ok {
+				values[idx] = v
+			}
+		}
+		return values, err
+	})
+	if result == nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(result))
+	for idx, v := range dropNotFound(result) {
+		values[uniq[idx]] = v
+	}
+	return values, remapStale(err, firstIdxs)
+}
+
+// FetchBatchIDs is same with Client.FetchBatchCtx, except that the keys are derived from ids by key,
+// fn receives the missing ids instead of their indexes, and the values are keyed by the ids.
+// the ids of the same key are fetched once, and all of them are in the result.
+// if the stale values are returned with a *StaleError, its Idxs are the indexes of the first ids of the stale keys in ids.
+func FetchBatchIDs[ID comparable](ctx context.Context, c *Client, ids []ID, key func(id ID) string, expire time.Duration, fn func(ctx context.Context, ids []ID) (map[ID]string, error)) (map[ID]string, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = key(id)
+	}
+	uniq, firstIdxs := dedupKeys(keys)
+	result, err := c.fetchKeys(ctx, uniq, expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		missIDs := make([]ID, len(idxs))
+		for i, idx := range idxs {
+			missIDs[i] = ids[firstIdxs[idx]]
+		}
+		data, err := fn(ctx, missIDs)
+		if data == nil {
+			return nil, err
+		}
+		values := make(map[int]string, len(data))
+		for i, idx := range idxs {
+			if v, ok := data[missIDs[i]]; ok {
+				values[idx] = v
+			}
+		}
+		return values, err
+	})
+	if result == nil {
+		return nil, err
+	}
+	byKey := make(map[string]string, len(result))
+	for idx, v := range dropNotFound(result) {
+		byKey[uniq[idx]] = v
+	}
+	values := make(map[ID]string, len(byKey))
+	for i, id := range ids {
+		if v, ok := byKey[keys[i]]; ok {
+			values[id] = v
+		}
+	}
+	return values, remapStale(err, firstIdxs)
+}
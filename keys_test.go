@@ -0,0 +1,91 @@
+package rockscache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchBatchKeys(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	var loaded [][]string
+	fn := func(ctx context.Context, keys []string) (map[string]string, error) {
+		sorted := append([]string(nil), keys...)
+		sort.Strings(sorted)
+		loaded = append(loaded, sorted)
+		values := make(map[string]string)
+		for _, key := range keys {
+			if key != "key_missing" {
+				values[key] = "value_" + key
+			}
+		}
+		return values, ErrNotFound
+	}
+	v, err := rc.FetchBatchKeys(ctx, []string{"key1", "key2", "key1", "key_missing"}, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"key1": "value_key1", "key2": "value_key2"}, v)
+	assert.Equal(t, [][]string{{"key1", "key2", "key_missing"}}, loaded)
+
+	v, err = rc.FetchBatchKeys(ctx, []string{"key2", "key3"}, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"key2": "value_key2", "key3": "value_key3"}, v)
+	assert.Equal(t, []string{"key3"}, loaded[1])
+}
+
+func TestFetchBatchKeysStale(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.StaleIfError = time.Second
+	rc := NewClient(rdb, opts)
+	_, err := rc.FetchBatchKeys(ctx, []string{"key1"}, 60*time.Second, func(ctx context.Context, keys []string) (map[string]string, error) {
+		return map[string]string{"key1": "value1"}, nil
+	})
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted("key1")
+	assert.Nil(t, err)
+	v, err := rc.FetchBatchKeys(ctx, []string{"key0", "key0", "key1"}, 60*time.Second, func(ctx context.Context, keys []string) (map[string]string, error) {
+		return nil, errDBDown
+	})
+	// key0 has no stale value
+	assert.Equal(t, errDBDown, err)
+	assert.Nil(t, v)
+	v, err = rc.FetchBatchKeys(ctx, []string{"key1", "key1"}, 60*time.Second, func(ctx context.Context, keys []string) (map[string]string, error) {
+		return nil, errDBDown
+	})
+	var se *StaleError
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, []int{0}, se.Idxs)
+	assert.Equal(t, map[string]string{"key1": "value1"}, v)
+}
+
+func TestFetchBatchIDs(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	key := func(id int) string { return fmt.Sprintf("user:%d", id) }
+	var loaded []int
+	fn := func(ctx context.Context, ids []int) (map[int]string, error) {
+		loaded = append(loaded, ids...)
+		values := make(map[int]string)
+		for _, id := range ids {
+			values[id] = fmt.Sprintf("name%d", id)
+		}
+		return values, nil
+	}
+	v, err := FetchBatchIDs(ctx, rc, []int{1, 2, 1}, key, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "name1", 2: "name2"}, v)
+	sort.Ints(loaded)
+	assert.Equal(t, []int{1, 2}, loaded)
+	assert.Equal(t, "name2", rdb.HGet(ctx, "user:2", "value").Val())
+
+	v, err = FetchBatchIDs(ctx, rc, []int{2, 3}, key, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{2: "name2", 3: "name3"}, v)
+	assert.Equal(t, 3, loaded[2])
+}
@@ -0,0 +1,104 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLockLost is returned when the lock is taken by another owner before the fetched data is stored,
+// the data has been fetched twice, and the data of the current owner is not stored.
+var ErrLockLost = errors.New("lock lost")
+
+// lockKeeper extends the lock of the keys held by owner, while the data is being fetched
+type lockKeeper struct {
+	c     *Client
+	keys  []string
+	owner string
+	// ctx is the context of fn, which is canceled when the lease of the lock expires
+	ctx    context.Context
+	cancel context.CancelFunc
+	stopCh chan struct{}
+}
+
+// keepLock extends the lock of keys every LockExpire/3 (at least 1ms), until stop is called or ctx is done.
+// lockedAt is the time before the locks are acquired, the lease of the locks ends LockExpire after it,
+// or LockExpire after the last renewal, then the context of the keeper is canceled,
+// so that fn is stopped before another owner may take the locks.
+func (c *Client) keepLock(ctx context.Context, keys []string, owner string, lockedAt time.Time) *lockKeeper {
+	c.ownedLocks.add(keys, owner)
+	if c.Options.DisableLockRenew || len(keys) == 0 {
+		lctx, cancel := context.WithDeadline(ctx, lockedAt.Add(c.Options.LockExpire))
+		return &lockKeeper{c: c, keys: keys, owner: owner, ctx: lctx, cancel: cancel}
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	k := &lockKeeper{c: c, keys: keys, owner: owner, ctx: lctx, cancel: cancel, stopCh: make(chan struct{})}
+	expired := time.AfterFunc(time.Until(lockedAt.Add(c.Options.LockExpire)), cancel)
+	go c.withRecover(func() {
+		defer expired.Stop()
+		interval := c.Options.LockExpire / 3
+		if interval < time.Millisecond {
+			interval = time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for len(keys) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-k.stopCh:
+				return
+			case <-ticker.C:
+			}
+			renewedAt := time.Now()
+			lost, err := c.store.Renew(ctx, keys, c.Options.LockExpire, owner)
+			if err != nil {
+				c.logger().Warn("renew lock failed", "keys", keys, "owner", owner, "err", err)
+				continue
+			}
+			keys = removeKeys(keys, lost)
+			expired.Reset(time.Until(renewedAt.Add(c.Options.LockExpire)))
+		}
+		cancel() // all the locks are taken by others
+	})
+	return k
+}
+
+func (k *lockKeeper) stop() {
+	if k.stopCh != nil {
+		close(k.stopCh)
+	}
+	k.cancel()
+	k.c.ownedLocks.remove(k.keys, k.owner)
+}
+
+func lockLostError(keys []string, owner string) error {
+	return fmt.Errorf("%w: %v has been locked by another owner than %s", ErrLockLost, keys, owner)
+}
+
+func removeKeys(keys []string, removed []string) []string {
+	if len(removed) == 0 {
+		return keys
+	}
+	var rm = make(map[string]bool, len(removed))
+	for _, key := range removed {
+		rm[key] = true
+	}
+	var left []string
+	for _, key := range keys {
+		if !rm[key] {
+			left = append(left, key)
+		}
+	}
+	return left
+}
+
+func toStrings(res interface{}) []string {
+	rs, _ := res.([]interface{})
+	var ss = make([]string, 0, len(rs))
+	for _, r := range rs {
+		ss = append(ss, r.(string))
+	}
+	return ss
+}
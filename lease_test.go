@@ -0,0 +1,134 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockRenew(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LockExpire = time.Second
+	rc := NewClient(rdb, opts)
+	rc.Options.StrongConsistency = true
+	expected := "value1"
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		dc2 := NewClient(rdb, opts)
+		dc2.Options.StrongConsistency = true
+		v, err := dc2.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v)
+	}()
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc(expected, 2500))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestLockLost(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := rc.LockForUpdate(ctx, rdbKey, "other_owner")
+		assert.Nil(t, err)
+	}()
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 100))
+	assert.ErrorIs(t, err, ErrLockLost)
+}
+
+func TestLockLostForBatch(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(10))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := rc.LockForUpdate(ctx, keys[3], "other_owner")
+		assert.Nil(t, err)
+	}()
+	values := genValues(10, "value_")
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values, 100))
+	assert.ErrorIs(t, err, ErrLockLost)
+	// the fetched values are returned with the error
+	assert.Equal(t, values, v)
+
+	// the other keys are stored
+	s, err := rc.RawGet(ctx, keys[4])
+	assert.Nil(t, err)
+	assert.Equal(t, "value_4", s)
+}
+
+// waitDone returns a loader waiting for its context to be done
+func waitDone(maxWait time.Duration) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(maxWait):
+			return "value1", nil
+		}
+	}
+}
+
+func TestFetchCtxDeadline(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	cctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := rc.FetchCtx(cctx, rdbKey, 60*time.Second, func(ctx context.Context) (string, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		want, _ := cctx.Deadline()
+		assert.Equal(t, want, deadline)
+		return waitDone(time.Second)(ctx)
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFetchCtxLeaseExpired(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LockExpire = 50 * time.Millisecond
+	opts.DisableLockRenew = true
+	rc := NewClient(rdb, opts)
+	began := time.Now()
+	_, err := rc.FetchCtx(ctx, rdbKey, 60*time.Second, waitDone(time.Second))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, time.Since(began) < 100*time.Millisecond)
+}
+
+func TestFetchCtxLockLost(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LockExpire = 90 * time.Millisecond
+	rc := NewClient(rdb, opts)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := rc.LockForUpdate(ctx, rdbKey, "other_owner")
+		assert.Nil(t, err)
+	}()
+	// fn is canceled when the renewal finds the lock taken by other, before the lease ends
+	began := time.Now()
+	_, err := rc.FetchCtx(ctx, rdbKey, 60*time.Second, waitDone(time.Second))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, time.Since(began) < 200*time.Millisecond)
+}
+
+func TestFetchBatchCtx(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	type ctxKey struct{}
+	keys := genKeys(genIdxs(3))
+	values := genValues(3, "value_")
+	v, err := rc.FetchBatchCtx(context.WithValue(ctx, ctxKey{}, "v"), keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		assert.Equal(t, "v", ctx.Value(ctxKey{}))
+		assert.Nil(t, ctx.Err())
+		return values, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, values, v)
+}
@@ -0,0 +1,127 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoaderOptions is the options of a Loader
+type LoaderOptions struct {
+	// Wait is the time to collect the keys of a batch, after the first key is loaded. default is 1ms
+	Wait time.Duration
+	// MaxBatch is the max number of keys of a batch, a full batch is fetched without waiting. default is 100
+	// if MaxBatch is 0, the number of keys is not limited.
+	MaxBatch int
+}
+
+// NewDefaultLoaderOptions return default loader options
+func NewDefaultLoaderOptions() LoaderOptions {
+	return LoaderOptions{
+		Wait:     time.Millisecond,
+		MaxBatch: 100,
+	}
+}
+
+// Loader collects the keys loaded concurrently, such as by the resolvers of a GraphQL query,
+// and fetches them by one batch with FetchBatchResults, so that the values are read by one script call,
+// and the missing values are fetched by one call of fn.
+type Loader struct {
+	c       *Client
+	expire  time.Duration
+	fn      func(ctx context.Context, keys []string) (map[string]string, error)
+	opts    LoaderOptions
+	mu      sync.Mutex
+	pending *loaderBatch
+}
+
+// loaderBatch is the keys collected by a Loader, and their results when done is closed
+type loaderBatch struct {
+	ctx     context.Context
+	keys    []string
+	added   map[string]bool
+	timer   *time.Timer
+	done    chan struct{}
+	results map[string]BatchResult
+	err     error
+}
+
+// NewLoader returns a Loader fetching the keys by c, the keys missing in cache are fetched by fn, and stored for expire.
+// fn receives the missing keys of a batch, and returns the values keyed by the keys, see FetchBatchKeys.
+func NewLoader(c *Client, expire time.Duration, fn func(ctx context.Context, keys []string) (map[string]string, error), opts LoaderOptions) *Loader {
+	return &Loader{c: c, expire: expire, fn: fn, opts: opts}
+}
+
+// Load returns the value of key, which is fetched with the other keys loaded in the same batch.
+// the batch is not canceled with ctx, which may be shared by other callers, but Load returns ctx.Err() when ctx is done.
+// the value or the error of key is returned as FetchBatchResults does, such as ErrNotFound or a *StaleError.
+func (l *Loader) Load(ctx context.Context, key string) (string, error) {
+	b := l.add(ctx, key)
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if b.err != nil {
+		return "", b.err
+	}
+	r := b.results[key]
+	return r.Value, r.Err
+}
+
+// add adds key to the pending batch, which is started if it does not exist, and fetched if it is full
+func (l *Loader) add(ctx context.Context, key string) *loaderBatch {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.pending
+	if b == nil {
+		// the batch runs on behalf of all the callers, the trace of the first caller is kept
+		b = &loaderBatch{ctx: detachedContext{parent: ctx}, added: make(map[string]bool), done: make(chan struct{})}
+		b.timer = time.AfterFunc(l.opts.Wait, func() { l.dispatch(b) })
+		l.pending = b
+	}
+	if !b.added[key] {
+		b.added[key] = true
+		b.keys = append(b.keys, key)
+	}
+	if l.opts.MaxBatch > 0 && len(b.keys) >= l.opts.MaxBatch {
+		b.timer.Stop()
+		l.pending = nil
+		go l.fetch(b)
+	}
+	return b
+}
+
+// dispatch fetches b when the wait is over, unless it is already fetched because it is full
+func (l *Loader) dispatch(b *loaderBatch) {
+	l.mu.Lock()
+	if l.pending != b {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+	l.fetch(b)
+}
+
+func (l *Loader) fetch(b *loaderBatch) {
+	defer close(b.done)
+	rs, err := l.c.FetchBatchResults(b.ctx, b.keys, l.expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		data, err := l.fn(ctx, pickKeys(b.keys, idxs))
+		if data == nil {
+			return nil, err
+		}
+		values := make(map[int]string, len(data))
+		for _, idx := range idxs {
+			if v, ok := data[b.keys[idx]]; ok {
+				values[idx] = v
+			}
+		}
+		return values, err
+	})
+	b.results = make(map[string]BatchResult, len(rs))
+	for idx, r := range rs {
+		b.results[b.keys[idx]] = r
+	}
+	b.err = err
+}
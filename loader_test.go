@@ -0,0 +1,110 @@
+package rockscache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loaderData returns the value of each key, and records the keys of each call
+func loaderData(mu *sync.Mutex, calls *[][]string) func(ctx context.Context, keys []string) (map[string]string, error) {
+	return func(ctx context.Context, keys []string) (map[string]string, error) {
+		mu.Lock()
+		sorted := append([]string(nil), keys...)
+		sort.Strings(sorted)
+		*calls = append(*calls, sorted)
+		mu.Unlock()
+		values := make(map[string]string)
+		for _, key := range keys {
+			if key != "key_missing" {
+				values[key] = "value_" + key
+			}
+		}
+		return values, ErrNotFound
+	}
+}
+
+func TestLoader(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	var mu sync.Mutex
+	var calls [][]string
+	opts := NewDefaultLoaderOptions()
+	opts.Wait = 20 * time.Millisecond
+	l := NewLoader(rc, 60*time.Second, loaderData(&mu, &calls), opts)
+
+	var wg sync.WaitGroup
+	keys := []string{"key1", "key2", "key3", "key1", "key_missing"}
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			v, err := l.Load(ctx, key)
+			if key == "key_missing" {
+				assert.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, "value_"+key, v)
+		}(key)
+	}
+	wg.Wait()
+	assert.Equal(t, [][]string{{"key1", "key2", "key3", "key_missing"}}, calls)
+
+	// the cached values are not fetched again
+	v, err := l.Load(ctx, "key2")
+	assert.Nil(t, err)
+	assert.Equal(t, "value_key2", v)
+	assert.Equal(t, 1, len(calls))
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	var mu sync.Mutex
+	var calls [][]string
+	l := NewLoader(rc, 60*time.Second, loaderData(&mu, &calls), LoaderOptions{Wait: time.Second, MaxBatch: 3})
+
+	began := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := l.Load(ctx, fmt.Sprintf("key%d", i))
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("value_key%d", i), v)
+		}(i)
+	}
+	wg.Wait()
+	// the full batches are fetched without waiting
+	assert.True(t, time.Since(began) < 500*time.Millisecond)
+	assert.Equal(t, 2, len(calls))
+	for _, keys := range calls {
+		assert.Equal(t, 3, len(keys))
+	}
+}
+
+func TestLoaderCanceled(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	l := NewLoader(rc, 60*time.Second, func(ctx context.Context, keys []string) (map[string]string, error) {
+		time.Sleep(100 * time.Millisecond)
+		return map[string]string{keys[0]: "value1"}, nil
+	}, NewDefaultLoaderOptions())
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err := l.Load(cctx, rdbKey)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the batch is not canceled with the caller
+	time.Sleep(100 * time.Millisecond)
+	v, err := l.Load(ctx, rdbKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+}
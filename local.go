@@ -0,0 +1,122 @@
+package rockscache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// localCache is a bounded LRU memory cache in front of redis.
+// all the methods are safe to be called on a nil *localCache, which means the local cache is disabled
+type localCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+	// version is increased on every invalidation, a value read before an invalidation should not be stored
+	version uint64
+}
+
+type localEntry struct {
+	key      string
+	value    string
+	expireAt time.Time
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	return &localCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *localCache) get(key string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.entries[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*localEntry)
+	if time.Now().After(e.expireAt) {
+		l.lru.Remove(el)
+		delete(l.entries, key)
+		return "", false
+	}
+	l.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (l *localCache) currentVersion() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.version
+}
+
+// set stores the value read from redis, if no invalidation happened since version
+func (l *localCache) set(key string, value string, version uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.version != version {
+		return
+	}
+	expireAt := time.Now().Add(l.ttl)
+	if el, ok := l.entries[key]; ok {
+		e := el.Value.(*localEntry)
+		e.value, e.expireAt = value, expireAt
+		l.lru.MoveToFront(el)
+		return
+	}
+	l.entries[key] = l.lru.PushFront(&localEntry{key: key, value: value, expireAt: expireAt})
+	for l.lru.Len() > l.size {
+		el := l.lru.Back()
+		l.lru.Remove(el)
+		delete(l.entries, el.Value.(*localEntry).key)
+	}
+}
+
+func (l *localCache) del(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.version++
+	for _, key := range keys {
+		if el, ok := l.entries[key]; ok {
+			l.lru.Remove(el)
+			delete(l.entries, key)
+		}
+	}
+}
+
+func (l *localCache) clear() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.version++
+	l.lru.Init()
+	l.entries = make(map[string]*list.Element)
+}
+
+// setLocalFetched stores the value returned by fn in local cache
+func (c *Client) setLocalFetched(key string, value string, version uint64) {
+	if value == "" && c.Options.EmptyExpire == 0 { // the empty result is not cached in redis either
+		return
+	}
+	c.local.set(key, value, version)
+}
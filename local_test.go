@@ -0,0 +1,128 @@
+package rockscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCache(t *testing.T) {
+	l := newLocalCache(2, 50*time.Millisecond)
+	l.set("k1", "v1", l.currentVersion())
+	l.set("k2", "v2", l.currentVersion())
+	v, ok := l.get("k1")
+	assert.True(t, ok)
+	assert.Equal(t, "v1", v)
+
+	// k2 is the least recently used
+	l.set("k3", "v3", l.currentVersion())
+	_, ok = l.get("k2")
+	assert.False(t, ok)
+
+	// a value read before invalidation is not stored
+	version := l.currentVersion()
+	l.del("k1")
+	l.set("k1", "v1", version)
+	_, ok = l.get("k1")
+	assert.False(t, ok)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = l.get("k3")
+	assert.False(t, ok)
+
+	var nl *localCache
+	nl.set("k1", "v1", nl.currentVersion())
+	_, ok = nl.get("k1")
+	assert.False(t, ok)
+}
+
+func TestLocalFetch(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LocalCacheSize = 100
+	rc := NewClient(rdb, opts)
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	// served from local cache, without reading redis
+	err = rdb.HSet(ctx, rdbKey, "value", "changed").Err()
+	assert.Nil(t, err)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	// tag deleted by another process
+	err = NewClient(rdb, NewDefaultOptions()).TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", v)
+
+	time.Sleep(20 * time.Millisecond)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestLocalFetchWhileRefreshing(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LocalCacheSize = 100
+	rc := NewClient(rdb, opts)
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+
+	dc := NewClient(rdb, NewDefaultOptions())
+	_, err := dc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = dc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	// the stale value is returned, and refreshed by dc in background
+	v, err := dc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 200))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	time.Sleep(20 * time.Millisecond)
+	// the stale value is returned, but not stored in local cache
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	time.Sleep(250 * time.Millisecond)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestLocalFetchBatch(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.LocalCacheSize = 100
+	rc := NewClient(rdb, opts)
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+
+	n := 10
+	keys, values1 := genKeys(genIdxs(n)), genValues(n, "value_")
+	v, err := rc.FetchBatch(keys[:5], 60*time.Second, genBatchDataFunc(values1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(5, "value_"), v)
+
+	var fetched []int
+	v, err = rc.FetchBatch(keys, 60*time.Second, func(idxs []int) (map[int]string, error) {
+		fetched = idxs
+		return values1, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+	assert.Equal(t, []int{5, 6, 7, 8, 9}, fetched)
+
+	err = NewClient(rdb, NewDefaultOptions()).TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, ok := rc.local.get(keys[0])
+	assert.False(t, ok)
+}
@@ -0,0 +1,65 @@
+package rockscache
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the structured logger of the client, *slog.Logger implements it.
+// args are alternating keys and values, the same as the args of slog.Logger.Info.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var verbose = false
+
+// SetVerbose sets verbose mode, in which the default logger writes the debug messages.
+//
+// Deprecated: set Options.Logger to a logger with the level you need.
+func SetVerbose(v bool) {
+	verbose = v
+}
+
+// stdLogger is the default Logger, which writes to the standard log package.
+// the debug messages are only written in verbose mode.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...any) {
+	if verbose {
+		logKV("DEBUG", msg, args)
+	}
+}
+
+func (stdLogger) Info(msg string, args ...any)  { logKV("INFO", msg, args) }
+func (stdLogger) Warn(msg string, args ...any)  { logKV("WARN", msg, args) }
+func (stdLogger) Error(msg string, args ...any) { logKV("ERROR", msg, args) }
+
+func logKV(level string, msg string, args []any) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" rockscache: ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+		}
+	}
+	log.Print(b.String())
+}
+
+func (o *Options) logger() Logger {
+	if o.Logger == nil {
+		return stdLogger{}
+	}
+	return o.Logger
+}
+
+func (c *Client) logger() Logger {
+	return c.Options.logger()
+}
@@ -0,0 +1,84 @@
+package rockscache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *recordLogger) record(level string, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, level+" "+msg+" "+fmt.Sprint(args...))
+}
+
+func (l *recordLogger) find(prefix string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+	for _, e := range l.events {
+		if len(e) >= len(prefix) && e[:len(prefix)] == prefix {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *recordLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+func TestLoggerAsyncRefreshFailed(t *testing.T) {
+	clearCache()
+	l := &recordLogger{}
+	opts := NewDefaultOptions()
+	opts.Logger = l
+	rc := NewClient(rdb, opts)
+
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, func() (string, error) {
+		return "", errors.New("db down")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	assert.Eventually(t, func() bool {
+		return len(l.find("WARN async refresh failed")) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.True(t, len(l.find("DEBUG weakFetch")) > 0)
+}
+
+func TestLoggerRecoveredPanic(t *testing.T) {
+	clearCache()
+	l := &recordLogger{}
+	opts := NewDefaultOptions()
+	opts.Logger = l
+	rc := NewClient(rdb, opts)
+	keys := genKeys(genIdxs(2))
+
+	_, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(2, "value_"), 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	_, err = rc.FetchBatch(keys, 60*time.Second, func(idxs []int) (map[int]string, error) {
+		panic("loader panic")
+	})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return len(l.find("ERROR recovered panic")) == 1
+	}, time.Second, 5*time.Millisecond)
+	panics := l.find("ERROR recovered panic")
+	assert.Contains(t, panics[0], "loader panic")
+}
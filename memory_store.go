@@ -0,0 +1,246 @@
+package rockscache
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore is a Store in process memory, with the same semantics as the redis store.
+// it can be used to test the code using rockscache without redis.
+// the invalidation and lock released messages are not published, so the local caches of other clients
+// are not invalidated, and the readers waiting for the lock only poll every LockSleep.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	// writes counts the writes since the last sweep of the expired entries
+	writes int
+}
+
+type memoryEntry struct {
+	value     string
+	hasValue  bool
+	lockUntil int64 // in milliseconds
+	hasLock   bool
+	lockOwner string
+	staleAt   int64 // in milliseconds, zero means not stale
+	errMsg    string
+	errUntil  int64     // in milliseconds
+	expireAt  time.Time // zero means never expire
+}
+
+// ttl returns the remaining time to live in milliseconds, -1 if it never expires
+func (e *memoryEntry) ttl(now time.Time) int64 {
+	if e.expireAt.IsZero() {
+		return -1
+	}
+	return e.expireAt.Sub(now).Milliseconds()
+}
+
+// lockForever is the lock time of LockForUpdate
+const lockForever = math.MaxInt64
+
+// memorySweepWrites is the number of writes between two sweeps of the expired entries
+const memorySweepWrites = 1000
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+// entry returns the entry of key, the expired entry is removed. it should be called with mu locked.
+func (s *MemoryStore) entry(key string, now time.Time) *memoryEntry {
+	e := s.entries[key]
+	if e != nil && !e.expireAt.IsZero() && !now.Before(e.expireAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+// writeEntry returns the entry of key for writing, it is created if not exists. it should be called with mu locked.
+func (s *MemoryStore) writeEntry(key string, now time.Time) *memoryEntry {
+	s.writes++
+	if s.writes >= memorySweepWrites {
+		s.writes = 0
+		for k, e := range s.entries {
+			if !e.expireAt.IsZero() && !now.Before(e.expireAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	e := s.entry(key, now)
+	if e == nil {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	nowMs := now.UnixMilli()
+	rets := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		var v, lu, sa, ce interface{}
+		e := s.entry(key, now)
+		if e != nil && e.hasValue {
+			v = e.value
+		}
+		if e != nil && e.staleAt != 0 {
+			sa = strconv.FormatInt(nowMs-e.staleAt, 10)
+		}
+		if e != nil && e.errUntil > nowMs {
+			ce = e.errMsg
+		}
+		if ce == nil && (e != nil && e.hasLock && e.lockUntil < nowMs || (e == nil || !e.hasLock) && v == nil) {
+			e = s.writeEntry(key, now)
+			e.lockUntil, e.hasLock, e.lockOwner = nowMs+lockExpire.Milliseconds(), true, owner
+			rets = append(rets, []interface{}{v, locked, sa, e.ttl(now), nil})
+			continue
+		}
+		if e.hasLock {
+			lu = strconv.FormatInt(e.lockUntil, 10)
+		}
+		rets = append(rets, []interface{}{v, lu, sa, e.ttl(now), ce})
+	}
+	return rets, nil
+}
+
+// Set implements Store
+func (s *MemoryStore) Set(ctx context.Context, keys []string, values []string, expires []time.Duration, owner string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var lost []string
+	for i, key := range keys {
+		e := s.entry(key, now)
+		if e == nil || e.lockOwner != owner {
+			if e != nil && e.lockOwner != "" {
+				lost = append(lost, key)
+			}
+			continue
+		}
+		e.value, e.hasValue, e.staleAt, e.errUntil = values[i], true, 0, 0
+		e.hasLock, e.lockUntil, e.lockOwner = false, 0, ""
+		e.expireAt = now.Add(expires[i])
+	}
+	return lost, nil
+}
+
+// CacheError implements Store
+func (s *MemoryStore) CacheError(ctx context.Context, keys []string, expires []time.Duration, owner string, message string, errorExpire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i, key := range keys {
+		e := s.entry(key, now)
+		if e == nil || e.lockOwner != owner {
+			continue
+		}
+		e.lockUntil, e.hasLock, e.lockOwner = 0, true, ""
+		e.errMsg, e.errUntil = message, now.Add(errorExpire).UnixMilli()
+		expire := expires[i]
+		if expire < errorExpire {
+			expire = errorExpire
+		}
+		e.expireAt = now.Add(expire)
+	}
+	return nil
+}
+
+// Renew implements Store
+func (s *MemoryStore) Renew(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var lost []string
+	for _, key := range keys {
+		e := s.entry(key, now)
+		if e == nil || e.lockOwner != owner {
+			lost = append(lost, key)
+			continue
+		}
+		e.lockUntil, e.hasLock = now.UnixMilli()+lockExpire.Milliseconds(), true
+	}
+	return lost, nil
+}
+
+// Lock implements Store
+func (s *MemoryStore) Lock(ctx context.Context, key string, owner string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e := s.entry(key, now)
+	if e != nil && e.hasLock && e.lockUntil >= lockForever && e.lockOwner != owner {
+		return e.lockOwner, nil
+	}
+	e = s.writeEntry(key, now)
+	e.lockUntil, e.hasLock, e.lockOwner = lockForever, true, owner
+	return locked, nil
+}
+
+// Unlock implements Store
+func (s *MemoryStore) Unlock(ctx context.Context, key string, owner string, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e := s.entry(key, now); e != nil && e.lockOwner == owner {
+		e.lockUntil, e.hasLock, e.lockOwner = 0, true, ""
+		e.expireAt = now.Add(expire)
+	}
+	return nil
+}
+
+// TagAsDeleted implements Store
+func (s *MemoryStore) TagAsDeleted(ctx context.Context, keys []string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		e := s.writeEntry(key, now)
+		e.lockUntil, e.hasLock, e.lockOwner, e.errUntil = 0, true, "", 0
+		if e.staleAt == 0 {
+			e.staleAt = now.UnixMilli()
+		}
+		e.expireAt = now.Add(delay)
+	}
+	return nil
+}
+
+// RawGet implements Store
+func (s *MemoryStore) RawGet(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(key, time.Now())
+	if e == nil || !e.hasValue {
+		return "", redis.Nil
+	}
+	return e.value, nil
+}
+
+// RawSet implements Store
+func (s *MemoryStore) RawSet(ctx context.Context, key string, value string, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e := s.writeEntry(key, now)
+	e.value, e.hasValue = value, true
+	e.expireAt = now.Add(expire)
+	return nil
+}
+
+// Del implements Store
+func (s *MemoryStore) Del(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
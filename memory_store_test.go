@@ -0,0 +1,127 @@
+package rockscache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryClient() *Client {
+	opts := NewDefaultOptions()
+	opts.Delay = 100 * time.Millisecond
+	opts.LockSleep = 10 * time.Millisecond
+	return NewClientWithStore(NewMemoryStore(), opts)
+}
+
+func TestMemoryWeakFetch(t *testing.T) {
+	rc := newMemoryClient()
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	// the stale value is returned, and refreshed in background
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 50))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	time.Sleep(80 * time.Millisecond)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestMemoryStrongFetch(t *testing.T) {
+	rc := newMemoryClient()
+	rc.Options.StrongConsistency = true
+	go func() {
+		_, _ = rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc("value1", 50))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	dc := NewClientWithStore(rc.store, rc.Options)
+	v, err := dc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err = dc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestMemoryEmptyAndExpire(t *testing.T) {
+	rc := newMemoryClient()
+	rc.Options.EmptyExpire = 50 * time.Millisecond
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "", v)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "", v)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = rc.RawGet(ctx, rdbKey)
+	assert.ErrorIs(t, err, redis.Nil)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+}
+
+func TestMemoryLockForUpdate(t *testing.T) {
+	rc := newMemoryClient()
+	rc.Options.StrongConsistency = true
+	err := rc.LockForUpdate(ctx, rdbKey, "owner1")
+	assert.Nil(t, err)
+	err = rc.LockForUpdate(ctx, rdbKey, "owner2")
+	assert.Error(t, err)
+
+	fetchError := errors.New("fetch error")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := rc.UnlockForUpdate(ctx, rdbKey, "owner1")
+		assert.Nil(t, err)
+	}()
+	began := time.Now()
+	_, err = rc.Fetch(rdbKey, 60*time.Second, func() (string, error) {
+		return "", fetchError
+	})
+	assert.ErrorIs(t, err, fetchError)
+	assert.True(t, time.Since(began) >= 50*time.Millisecond)
+}
+
+func TestMemoryFetchBatch(t *testing.T) {
+	rc := newMemoryClient()
+	n := 10
+	keys, values1, values2 := genKeys(genIdxs(n)), genValues(n, "value_"), genValues(n, "eulav_")
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values2, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+	time.Sleep(20 * time.Millisecond)
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, values2, v)
+}
+
+func TestMemoryLockLost(t *testing.T) {
+	rc := newMemoryClient()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		err := rc.LockForUpdate(ctx, rdbKey, "other_owner")
+		assert.Nil(t, err)
+	}()
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 50))
+	assert.ErrorIs(t, err, ErrLockLost)
+	assert.Equal(t, "value1", v)
+	_, err = rc.RawGet(ctx, rdbKey)
+	assert.ErrorIs(t, err, redis.Nil)
+}
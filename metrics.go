@@ -0,0 +1,71 @@
+package rockscache
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics receives the events of the client, see the sub package prommetrics for a Prometheus implementation.
+// the methods are called in the fetching path, so they should be fast and safe for concurrent use.
+type Metrics interface {
+	// Hit is called when the value of key is returned from cache
+	Hit(key string)
+	// Miss is called when the value of key is not in cache, and is fetched by fn synchronously
+	Miss(key string)
+	// StaleServed is called when a stale value of key is returned, while it is being refreshed
+	StaleServed(key string)
+	// StaleIfError is called when fn fails, and the stale value of key is served instead in StaleIfError mode,
+	// age is the time since the value is tag deleted
+	StaleIfError(key string, age time.Duration)
+	// AsyncRefresh is called when the value of key is refreshed in background
+	AsyncRefresh(key string)
+	// EmptyCached is called when fn returns an empty result for key, which is cached with EmptyExpire
+	EmptyCached(key string)
+	// LockWait is called when the reader stops waiting for the lock of key locked by other,
+	// with the number of waits and the total duration
+	LockWait(key string, waits int, duration time.Duration)
+	// Fetched is called when fn returns, keys are the keys fetched by fn
+	Fetched(keys []string, duration time.Duration, err error)
+	// Script is called when a redis script returns, script is the name of the script
+	Script(script string, duration time.Duration, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Hit(key string)                                           {}
+func (noopMetrics) Miss(key string)                                          {}
+func (noopMetrics) StaleServed(key string)                                   {}
+func (noopMetrics) StaleIfError(key string, age time.Duration)               {}
+func (noopMetrics) AsyncRefresh(key string)                                  {}
+func (noopMetrics) EmptyCached(key string)                                   {}
+func (noopMetrics) LockWait(key string, waits int, duration time.Duration)   {}
+func (noopMetrics) Fetched(keys []string, duration time.Duration, err error) {}
+func (noopMetrics) Script(script string, duration time.Duration, err error)  {}
+
+func (c *Client) metrics() Metrics {
+	if c.Options.Metrics == nil {
+		return noopMetrics{}
+	}
+	return c.Options.Metrics
+}
+
+// hit records the value of key returned from cache to Options.Metrics, the span and the FetchInfo in ctx
+func (c *Client) hit(ctx context.Context, key string) {
+	c.metrics().Hit(key)
+	statsFromContext(ctx).hit()
+	infoFromContext(ctx).outcome(key, OutcomeHit)
+}
+
+// miss records the value of key fetched by fn synchronously to Options.Metrics, the span and the FetchInfo in ctx
+func (c *Client) miss(ctx context.Context, key string) {
+	c.metrics().Miss(key)
+	statsFromContext(ctx).miss()
+	infoFromContext(ctx).outcome(key, OutcomeMiss)
+}
+
+// staleServed records the stale value of key returned to Options.Metrics, the span and the FetchInfo in ctx
+func (c *Client) staleServed(ctx context.Context, key string) {
+	c.metrics().StaleServed(key)
+	statsFromContext(ctx).staleServed()
+	infoFromContext(ctx).outcome(key, OutcomeStale)
+}
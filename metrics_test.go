@@ -0,0 +1,80 @@
+package rockscache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *countMetrics) inc(event string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[event] += n
+}
+
+func (m *countMetrics) get(event string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[event]
+}
+
+func (m *countMetrics) Hit(key string)          { m.inc("hit", 1) }
+func (m *countMetrics) Miss(key string)         { m.inc("miss", 1) }
+func (m *countMetrics) StaleServed(key string)  { m.inc("stale", 1) }
+func (m *countMetrics) AsyncRefresh(key string) { m.inc("async", 1) }
+func (m *countMetrics) EmptyCached(key string)  { m.inc("empty", 1) }
+func (m *countMetrics) StaleIfError(key string, age time.Duration) {
+	m.inc("staleIfError", 1)
+}
+func (m *countMetrics) LockWait(key string, waits int, duration time.Duration) {
+	m.inc("lockWait", waits)
+}
+func (m *countMetrics) Fetched(keys []string, duration time.Duration, err error) {
+	m.inc("fetched", len(keys))
+}
+func (m *countMetrics) Script(script string, duration time.Duration, err error) {
+	m.inc("script:"+script, 1)
+}
+
+func TestMetrics(t *testing.T) {
+	clearCache()
+	m := &countMetrics{counts: map[string]int{}}
+	opts := NewDefaultOptions()
+	opts.Metrics = m
+	rc := NewClient(rdb, opts)
+
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	_, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	_, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 50))
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	// waits for the lock of the async refresh in strong consistency mode
+	rc.Options.StrongConsistency = true
+	_, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+
+	keys := genKeys(genIdxs(3))
+	_, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(map[int]string{}, 0))
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, m.get("hit"))
+	assert.Equal(t, 4, m.get("miss"))
+	assert.Equal(t, 1, m.get("stale"))
+	assert.Equal(t, 1, m.get("async"))
+	assert.Equal(t, 3, m.get("empty"))
+	assert.True(t, m.get("lockWait") > 0)
+	assert.Equal(t, 5, m.get("fetched"))
+	assert.Equal(t, 5, m.get("script:get"))
+	assert.Equal(t, 1, m.get("script:getBatch"))
+}
@@ -0,0 +1,52 @@
+package rockscache
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by fn if the data of the key does not exist, and is cached for NotFoundExpire.
+// then Fetch returns ErrNotFound, and the index of the key is not in the result of FetchBatch.
+// a batch fn returns ErrNotFound with the data found, the indexes not in the data are not found.
+var ErrNotFound = errors.New("not found")
+
+// notFoundValue is the value of the not found result in Store and the local cache.
+// it is converted to ErrNotFound before returned to the caller.
+const notFoundValue = "\x00rockscache:not-found\x00"
+
+// markNotFound marks the idxs not in data as not found, if fn returns ErrNotFound
+func markNotFound(data map[int]string, idxs []int, err error) (map[int]string, error) {
+	if !errors.Is(err, ErrNotFound) {
+		return data, err
+	}
+	if data == nil {
+		data = make(map[int]string, len(idxs))
+	}
+	for _, idx := range idxs {
+		if _, ok := data[idx]; !ok {
+			data[idx] = notFoundValue
+		}
+	}
+	return data, nil
+}
+
+// dropNotFound removes the not found results from the result of a batch
+func dropNotFound(result map[int]string) map[int]string {
+	for idx, v := range result {
+		if v == notFoundValue {
+			delete(result, idx)
+		}
+	}
+	return result
+}
+
+// notFoundInfo converts the not found result of a key to ErrNotFound
+func notFoundInfo(info FetchInfo, err error) (FetchInfo, error) {
+	if info.Value != notFoundValue {
+		return info, err
+	}
+	info.Value, info.NotFound = "", true
+	if err == nil {
+		err = ErrNotFound
+	}
+	return info, err
+}
@@ -0,0 +1,97 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func notFoundData() (string, error) { return "", ErrNotFound }
+
+func TestNotFound(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.NotFoundExpire = 30 * time.Second
+	rc := NewClient(rdb, opts)
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, notFoundData)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, "", v)
+	// the marker is empty for the older versions
+	assert.Equal(t, "1", rdb.HGet(ctx, rdbKey, "notFound").Val())
+	s, err := rc.RawGet(ctx, rdbKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "", s)
+	ttl := rdb.PTTL(ctx, rdbKey).Val()
+	assert.True(t, ttl > 20*time.Second && ttl <= 30*time.Second, ttl)
+
+	info, err := rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("ignored"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, OutcomeHit, info.Outcome)
+	assert.True(t, info.NotFound)
+	assert.False(t, info.Empty)
+
+	// a stored empty string is not a not found result
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	rc.Options.StrongConsistency = true
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "", v)
+	assert.False(t, rdb.HExists(ctx, rdbKey, "notFound").Val())
+}
+
+func TestNotFoundNotCached(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.NotFoundExpire = 0
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, notFoundData)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int64(0), rdb.Exists(ctx, rdbKey).Val())
+}
+
+func TestNotFoundLocal(t *testing.T) {
+	for _, rc := range []*Client{NewClient(rdb, NewDefaultOptions()), newMemoryClient()} {
+		clearCache()
+		rc.Options.LocalCacheSize = 10
+		rc.local = newLocalCache(10, rc.Options.LocalCacheTTL)
+		_, err := rc.Fetch(rdbKey, 60*time.Second, notFoundData)
+		assert.ErrorIs(t, err, ErrNotFound)
+		info, err := rc.FetchWithInfo(ctx, rdbKey, 60*time.Second, ctxData("ignored"))
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.True(t, info.Local)
+		assert.True(t, info.NotFound)
+	}
+}
+
+func TestNotFoundBatch(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(3))
+	fn := func(idxs []int) (map[int]string, error) {
+		return map[int]string{0: "value_0"}, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		v, err := rc.FetchBatch(keys, 60*time.Second, fn)
+		assert.Nil(t, err)
+		assert.Equal(t, map[int]string{0: "value_0"}, v)
+	}
+
+	infos, err := rc.FetchBatchWithInfo(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		return fn(idxs)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+	assert.False(t, infos[0].NotFound)
+	assert.True(t, infos[1].NotFound)
+	assert.True(t, infos[2].NotFound)
+	assert.Equal(t, "", infos[2].Value)
+
+	rc.Options.DisableCacheRead = true
+	v, err := rc.FetchBatch(keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{0: "value_0"}, v)
+}
@@ -0,0 +1,188 @@
+package rockscache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// notifier wakes up the waiters of a key, when the lock of the key is released by other processes.
+// all the methods are safe to be called on a nil *notifier, which means the waiters only poll by LockSleep
+type notifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{waiters: make(map[string]map[chan struct{}]struct{})}
+}
+
+// watch registers a waiter for key. the key should be read again after watching,
+// so that a notification between the reading and the waiting is not lost.
+func (n *notifier) watch(key string) chan struct{} {
+	if n == nil {
+		return nil
+	}
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.waiters[key] == nil {
+		n.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[key][ch] = struct{}{}
+	return ch
+}
+
+func (n *notifier) unwatch(key string, ch chan struct{}) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.waiters[key], ch)
+	if len(n.waiters[key]) == 0 {
+		delete(n.waiters, key)
+	}
+}
+
+func (n *notifier) notify(key string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.waiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default: // already notified
+		}
+	}
+}
+
+func (n *notifier) notifyAll() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	keys := make([]string, 0, len(n.waiters))
+	for key := range n.waiters {
+		keys = append(keys, key)
+	}
+	n.mu.Unlock()
+	for _, key := range keys {
+		n.notify(key)
+	}
+}
+
+// lockWaiter waits for the lock of key locked by other.
+// the waiter is only registered when the key is found locked by other,
+// so the readers of unlocked keys do not pay for the notification.
+type lockWaiter struct {
+	c        *Client
+	key      string
+	ch       chan struct{}
+	watching bool
+	waits    int
+	began    time.Time
+}
+
+func (c *Client) newLockWaiter(key string) *lockWaiter {
+	return &lockWaiter{c: c, key: key}
+}
+
+// wait waits until the lock of key is released, or LockSleep passed if the notification is lost.
+// on the first call with notification enabled, it only registers the waiter and returns,
+// the caller should read the key again, because the lock may be released before watching.
+func (w *lockWaiter) wait(ctx context.Context) error {
+	if w.c.notifier != nil && !w.watching {
+		w.watching = true
+		w.c.startSubscriber()
+		w.ch = w.c.notifier.watch(w.key)
+		return ctx.Err()
+	}
+	if w.waits == 0 {
+		w.began = time.Now()
+	}
+	w.waits++
+	statsFromContext(ctx).lockWait()
+	began := time.Now()
+	defer func() { infoFromContext(ctx).lockWait(w.key, time.Since(began)) }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.ch:
+		// notified by the lock owner
+	case <-time.After(w.c.Options.LockSleep):
+		// equal to time.Sleep(c.Options.LockSleep) but can be canceled
+	}
+	return nil
+}
+
+func (w *lockWaiter) stop() {
+	if w.watching {
+		w.c.notifier.unwatch(w.key, w.ch)
+	}
+	if w.waits > 0 {
+		w.c.metrics().LockWait(w.key, w.waits, time.Since(w.began))
+	}
+}
+
+func (c *Client) startSubscriber() {
+	c.subscribeOnce.Do(func() {
+		go c.subscribe()
+	})
+}
+
+// subscribe receives the invalidation and the lock released messages published by all processes.
+// on every (re)subscription, the local cache is cleared and all the waiters are woken up,
+// because the messages may be lost while disconnected.
+func (c *Client) subscribe() {
+	var channels []string
+	if c.local != nil && c.Options.InvalidationChannel != "" {
+		channels = append(channels, c.Options.InvalidationChannel)
+	}
+	if c.notifier != nil {
+		channels = append(channels, c.Options.NotifyChannel)
+	}
+	if len(channels) == 0 {
+		return
+	}
+	c.subMu.Lock()
+	if c.subClosed {
+		c.subMu.Unlock()
+		return
+	}
+	sub := c.rdb.Subscribe(context.Background(), channels...)
+	c.pubsub = sub
+	c.subMu.Unlock()
+	for msg := range sub.ChannelWithSubscriptions() {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			c.logger().Debug("subscribed", "channel", m.Channel)
+			if m.Channel == c.Options.InvalidationChannel {
+				c.local.clear()
+			} else {
+				c.notifier.notifyAll()
+			}
+		case *redis.Message:
+			c.logger().Debug("received", "channel", m.Channel, "key", m.Payload)
+			if m.Channel == c.Options.InvalidationChannel {
+				c.local.del(m.Payload)
+			}
+			c.notifier.notify(m.Payload)
+		}
+	}
+}
+
+// closeSubscriber stops receiving the messages, and the subscriber will not be started again
+func (c *Client) closeSubscriber() error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	c.subClosed = true
+	if c.pubsub == nil {
+		return nil
+	}
+	return c.pubsub.Close()
+}
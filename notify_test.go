@@ -0,0 +1,112 @@
+package rockscache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newNotifyClient() *Client {
+	opts := NewDefaultOptions()
+	opts.LockSleep = time.Second
+	opts.NotifyChannel = "rockscache:notify"
+	return NewClient(rdb, opts)
+}
+
+func TestNotifyFetch(t *testing.T) {
+	clearCache()
+	rc := newNotifyClient()
+	rc.startSubscriber()
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+	expected := "value1"
+	go func() {
+		v, err := newNotifyClient().Fetch(rdbKey, 60*time.Second, genDataFunc(expected, 200))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	began := time.Now()
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 200))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+	assert.True(t, time.Since(began) < 500*time.Millisecond)
+}
+
+func TestNotifyStrongFetchUnlock(t *testing.T) {
+	clearCache()
+	rc := newNotifyClient()
+	rc.Options.StrongConsistency = true
+	rc.startSubscriber()
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+	fetchError := errors.New("fetch error")
+	go func() {
+		_, err := newNotifyClient().Fetch(rdbKey, 60*time.Second, func() (string, error) {
+			time.Sleep(200 * time.Millisecond)
+			return "", fetchError
+		})
+		assert.ErrorIs(t, err, fetchError)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	began := time.Now()
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+	assert.True(t, time.Since(began) < 500*time.Millisecond)
+}
+
+func TestNotifyFetchBatch(t *testing.T) {
+	clearCache()
+	rc := newNotifyClient()
+	rc.startSubscriber()
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+	n := 10
+	keys, values1 := genKeys(genIdxs(n)), genValues(n, "value_")
+	go func() {
+		v, err := newNotifyClient().FetchBatch(keys, 60*time.Second, genBatchDataFunc(values1, 200))
+		assert.Nil(t, err)
+		assert.Equal(t, values1, v)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	began := time.Now()
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(n, "eulav_"), 200))
+	assert.Nil(t, err)
+	assert.Equal(t, values1, v)
+	assert.True(t, time.Since(began) < 500*time.Millisecond)
+}
+
+func TestNotifier(t *testing.T) {
+	n := newNotifier()
+	ch := n.watch("key1")
+	n.notify("key1")
+	n.notify("key1") // not blocked
+	<-ch
+	n.notifyAll()
+	<-ch
+	n.unwatch("key1", ch)
+	assert.Empty(t, n.waiters)
+
+	var nn *notifier
+	assert.Nil(t, nn.watch("key1"))
+	nn.notify("key1")
+}
+
+func TestCloseSubscriber(t *testing.T) {
+	clearCache()
+	rc := newNotifyClient()
+	rc.startSubscriber()
+	time.Sleep(20 * time.Millisecond) // wait for subscribed
+	assert.Nil(t, rc.closeSubscriber())
+	assert.Error(t, rc.pubsub.Ping(ctx))
+
+	// the subscriber is not started after closed
+	rc = newNotifyClient()
+	assert.Nil(t, rc.closeSubscriber())
+	rc.startSubscriber()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, rc.pubsub)
+}
@@ -0,0 +1,172 @@
+// Package prommetrics provides a Prometheus collector, which receives the metrics of rockscache.
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/dtm-labs/rockscache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options represents the options for Collector
+type Options struct {
+	// Namespace is the namespace of the metric names. default is "rockscache"
+	Namespace string
+	// KeyGroup returns the group of the key, which is used as the "group" label.
+	// it should return a bounded set of groups, such as the prefix of the keys. default returns "all" for all the keys
+	KeyGroup func(key string) string
+}
+
+// Collector implements rockscache.Metrics and prometheus.Collector.
+// set it to rockscache.Options.Metrics, and register it to a prometheus registry.
+type Collector struct {
+	keyGroup      func(key string) string
+	requests      *prometheus.CounterVec
+	staleIfError  *prometheus.CounterVec
+	asyncRefresh  *prometheus.CounterVec
+	emptyCached   *prometheus.CounterVec
+	lockWaits     *prometheus.CounterVec
+	lockWaitTime  *prometheus.HistogramVec
+	fetchDuration *prometheus.HistogramVec
+	fetchErrors   *prometheus.CounterVec
+	scriptTime    *prometheus.HistogramVec
+	scriptErrors  *prometheus.CounterVec
+}
+
+var _ rockscache.Metrics = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+// New returns a new Collector
+func New(opts Options) *Collector {
+	ns := opts.Namespace
+	if ns == "" {
+		ns = "rockscache"
+	}
+	keyGroup := opts.KeyGroup
+	if keyGroup == nil {
+		keyGroup = func(string) string { return "all" }
+	}
+	group := []string{"group"}
+	return &Collector{
+		keyGroup: keyGroup,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "requests_total",
+			Help: "Number of keys read, by result: hit, miss or stale.",
+		}, []string{"group", "result"}),
+		staleIfError: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "stale_if_error_total",
+			Help: "Number of keys whose stale value is served because the fetch function failed.",
+		}, group),
+		asyncRefresh: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "async_refreshes_total",
+			Help: "Number of keys refreshed in background.",
+		}, group),
+		emptyCached: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "empty_cached_total",
+			Help: "Number of empty results cached with EmptyExpire.",
+		}, group),
+		lockWaits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "lock_waits_total",
+			Help: "Number of waits for the locks held by others.",
+		}, group),
+		lockWaitTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "lock_wait_seconds",
+			Help:    "Time spent waiting for the locks held by others.",
+			Buckets: prometheus.DefBuckets,
+		}, group),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "fetch_seconds",
+			Help:    "Latency of the fetch function.",
+			Buckets: prometheus.DefBuckets,
+		}, group),
+		fetchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "fetch_errors_total",
+			Help: "Number of errors returned by the fetch function.",
+		}, group),
+		scriptTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "script_seconds",
+			Help:    "Latency of the redis scripts.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"script"}),
+		scriptErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "script_errors_total",
+			Help: "Number of errors returned by the redis scripts.",
+		}, []string{"script"}),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.requests, c.staleIfError, c.asyncRefresh, c.emptyCached, c.lockWaits, c.lockWaitTime,
+		c.fetchDuration, c.fetchErrors, c.scriptTime, c.scriptErrors}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+// Hit implements rockscache.Metrics
+func (c *Collector) Hit(key string) {
+	c.requests.WithLabelValues(c.keyGroup(key), "hit").Inc()
+}
+
+// Miss implements rockscache.Metrics
+func (c *Collector) Miss(key string) {
+	c.requests.WithLabelValues(c.keyGroup(key), "miss").Inc()
+}
+
+// StaleServed implements rockscache.Metrics
+func (c *Collector) StaleServed(key string) {
+	c.requests.WithLabelValues(c.keyGroup(key), "stale").Inc()
+}
+
+// StaleIfError implements rockscache.Metrics
+func (c *Collector) StaleIfError(key string, age time.Duration) {
+	c.staleIfError.WithLabelValues(c.keyGroup(key)).Inc()
+}
+
+// AsyncRefresh implements rockscache.Metrics
+func (c *Collector) AsyncRefresh(key string) {
+	c.asyncRefresh.WithLabelValues(c.keyGroup(key)).Inc()
+}
+
+// EmptyCached implements rockscache.Metrics
+func (c *Collector) EmptyCached(key string) {
+	c.emptyCached.WithLabelValues(c.keyGroup(key)).Inc()
+}
+
+// LockWait implements rockscache.Metrics
+func (c *Collector) LockWait(key string, waits int, duration time.Duration) {
+	group := c.keyGroup(key)
+	c.lockWaits.WithLabelValues(group).Add(float64(waits))
+	c.lockWaitTime.WithLabelValues(group).Observe(duration.Seconds())
+}
+
+// Fetched implements rockscache.Metrics, the keys of a batch are labeled by the group of the first key
+func (c *Collector) Fetched(keys []string, duration time.Duration, err error) {
+	if len(keys) == 0 {
+		return
+	}
+	group := c.keyGroup(keys[0])
+	c.fetchDuration.WithLabelValues(group).Observe(duration.Seconds())
+	if err != nil {
+		c.fetchErrors.WithLabelValues(group).Inc()
+	}
+}
+
+// Script implements rockscache.Metrics
+func (c *Collector) Script(script string, duration time.Duration, err error) {
+	c.scriptTime.WithLabelValues(script).Observe(duration.Seconds())
+	if err != nil {
+		c.scriptErrors.WithLabelValues(script).Inc()
+	}
+}
@@ -0,0 +1,47 @@
+package prommetrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	c := New(Options{KeyGroup: func(key string) string {
+		return strings.SplitN(key, ":", 2)[0]
+	}})
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, reg.Register(c))
+
+	c.Hit("user:1")
+	c.Hit("user:2")
+	c.Miss("user:3")
+	c.StaleServed("order:1")
+	c.StaleIfError("order:1", time.Second)
+	c.AsyncRefresh("order:1")
+	c.EmptyCached("user:4")
+	c.LockWait("user:5", 3, 300*time.Millisecond)
+	c.Fetched([]string{"user:3"}, 10*time.Millisecond, errors.New("fetch error"))
+	c.Fetched(nil, 0, nil)
+	c.Script("get", time.Millisecond, nil)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.requests.WithLabelValues("user", "hit")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.requests.WithLabelValues("user", "miss")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.requests.WithLabelValues("order", "stale")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.staleIfError.WithLabelValues("order")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.asyncRefresh.WithLabelValues("order")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.emptyCached.WithLabelValues("user")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(c.lockWaits.WithLabelValues("user")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.fetchErrors.WithLabelValues("user")))
+	assert.Equal(t, 0, testutil.CollectAndCount(c.scriptErrors))
+	assert.Equal(t, 1, testutil.CollectAndCount(c.scriptTime))
+
+	n, err := testutil.GatherAndCount(reg)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+}
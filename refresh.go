@@ -0,0 +1,241 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// detachedContext keeps the values of the parent, such as the trace span, but is not canceled with the parent.
+// the span stats, the FetchInfo, the failures and the streamer of the parent are not kept, because they are done when the parent returns.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case spanStatsKey, infoRecorderKey, failuresKey, streamerKey:
+		return nil
+	}
+	return d.parent.Value(key)
+}
+
+// asyncContext returns the context of the async refresh started by the caller with ctx.
+// the caller may return and cancel ctx before the refresh finishes, so it is detached from ctx,
+// and times out after AsyncRefreshTimeout instead.
+func (c *Client) asyncContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = detachedContext{parent: ctx}
+	if c.Options.AsyncRefreshTimeout > 0 {
+		return context.WithTimeout(ctx, c.Options.AsyncRefreshTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// failureTracker counts the consecutive failures of the async refreshes of each key.
+// a key is removed when it is fetched successfully, so only the failing keys are kept.
+type failureTracker struct {
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func (t *failureTracker) fail(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failures == nil {
+		t.failures = make(map[string]int)
+	}
+	t.failures[key]++
+	return t.failures[key]
+}
+
+func (t *failureTracker) count(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failures[key]
+}
+
+func (t *failureTracker) reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.failures) == 0 {
+		return
+	}
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+// refreshFailing returns true if the async refresh of key has failed AsyncRefreshMaxFailures times in a row,
+// then the stale value of key should be fetched synchronously, so that the error is returned to the caller.
+func (c *Client) refreshFailing(key string) bool {
+	return c.Options.AsyncRefreshMaxFailures > 0 && c.refreshFailures.count(key) >= c.Options.AsyncRefreshMaxFailures
+}
+
+// asyncRefreshFailed reports the failed async refresh of keys to the logger and OnAsyncRefreshError.
+// ErrLockLost is not counted as a failure, because fn has succeeded.
+// the error of fn is reported instead of the StaleError, because the stale value is not returned by the refresh.
+func (c *Client) asyncRefreshFailed(keys []string, owner string, err error) {
+	var se *StaleError
+	if errors.As(err, &se) {
+		err = se.Err
+	}
+	c.logger().Warn("async refresh failed", "keys", keys, "owner", owner, "err", err)
+	if errors.Is(err, ErrLockLost) {
+		return
+	}
+	for _, key := range keys {
+		failures := c.refreshFailures.fail(key)
+		if c.Options.OnAsyncRefreshError != nil {
+			c.Options.OnAsyncRefreshError(key, failures, err)
+		}
+	}
+}
+
+// RefreshFullPolicy is what to do with an async refresh, when all the workers are busy and the queue is full
+type RefreshFullPolicy int
+
+const (
+	// RefreshDrop drops the refresh, and the stale value is returned.
+	// the lock of the key is left to expire after LockExpire, then the key is refreshed again.
+	RefreshDrop RefreshFullPolicy = iota
+	// RefreshSync fetches the value synchronously, as if the value is not in cache.
+	RefreshSync
+)
+
+// refreshExecutor runs the async refreshes by at most AsyncRefreshWorkers goroutines,
+// with at most AsyncRefreshQueue refreshes waiting, and one refresh of a key at a time.
+// after closed, no refresh is accepted, and wg is waited for the accepted ones.
+type refreshExecutor struct {
+	mu       sync.Mutex
+	inflight map[string]bool
+	running  int
+	queue    []func()
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+var (
+	errRefreshQueueFull = errors.New("async refresh queue is full")
+	errRefreshClosed    = errors.New("client is closed")
+)
+
+// reserve marks keys[idxs] as being refreshed, and returns the idxs which are not being refreshed before
+func (e *refreshExecutor) reserve(keys []string, idxs []int) []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.inflight == nil {
+		e.inflight = make(map[string]bool)
+	}
+	var reserved []int
+	var mine = make(map[string]bool, len(idxs)) // the duplicated keys of a batch are locked by the same owner
+	for _, idx := range idxs {
+		key := keys[idx]
+		if e.inflight[key] && !mine[key] {
+			continue
+		}
+		e.inflight[key] = true
+		mine[key] = true
+		reserved = append(reserved, idx)
+	}
+	return reserved
+}
+
+func (e *refreshExecutor) release(keys []string, idxs []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, idx := range idxs {
+		delete(e.inflight, keys[idx])
+	}
+}
+
+// submitRefresh calls refresh(idxs) in background for the stale keys[idxs] locked by the caller.
+// the keys being refreshed by this process are skipped, so that they are not fetched twice.
+// if the workers are busy and the queue is full, the idxs are returned to be fetched synchronously
+// if AsyncRefreshFullPolicy is RefreshSync, otherwise they are dropped.
+// the locks of the skipped and dropped keys are left to expire after LockExpire.
+// after the client is closed, the idxs are always returned to be fetched synchronously.
+func (c *Client) submitRefresh(keys []string, idxs []int, owner string, refresh func(idxs []int)) (syncIdxs []int) {
+	e := &c.refresher
+	reserved := e.reserve(keys, idxs)
+	if len(reserved) < len(idxs) {
+		c.logger().Debug("skip the keys being refreshed", "keys", len(idxs)-len(reserved))
+	}
+	if len(reserved) == 0 {
+		return nil
+	}
+	lockedKeys := pickKeys(keys, reserved)
+	c.ownedLocks.add(lockedKeys, owner)
+	task := func() {
+		defer e.release(keys, reserved)
+		defer c.ownedLocks.remove(lockedKeys, owner)
+		c.withRecover(func() { refresh(reserved) })
+	}
+	if err := c.runRefresh(task); err != nil {
+		e.release(keys, reserved)
+		c.ownedLocks.remove(lockedKeys, owner)
+		if err == errRefreshClosed || c.Options.AsyncRefreshFullPolicy == RefreshSync {
+			return reserved
+		}
+		c.logger().Warn("async refresh queue is full, refresh dropped", "keys", lockedKeys)
+		return nil
+	}
+	for _, idx := range reserved {
+		c.metrics().AsyncRefresh(keys[idx])
+	}
+	return nil
+}
+
+// runRefresh runs task by a worker, or queues it if the workers are busy.
+// errRefreshQueueFull is returned if the queue is full, and errRefreshClosed if the client is closed.
+func (c *Client) runRefresh(task func()) error {
+	e := &c.refresher
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errRefreshClosed
+	}
+	workers := c.Options.AsyncRefreshWorkers
+	if workers <= 0 {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			task()
+		}()
+		return nil
+	}
+	if e.running < workers {
+		e.running++
+		e.wg.Add(1)
+		go e.work(task)
+		return nil
+	}
+	if len(e.queue) < c.Options.AsyncRefreshQueue {
+		e.wg.Add(1)
+		e.queue = append(e.queue, task)
+		return nil
+	}
+	return errRefreshQueueFull
+}
+
+// work runs task, and then the queued tasks until the queue is empty
+func (e *refreshExecutor) work(task func()) {
+	for task != nil {
+		task()
+		e.wg.Done()
+		e.mu.Lock()
+		task = nil
+		if len(e.queue) > 0 {
+			task = e.queue[0]
+			e.queue[0] = nil
+			e.queue = e.queue[1:]
+		} else {
+			e.running--
+		}
+		e.mu.Unlock()
+	}
+}
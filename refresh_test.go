@@ -0,0 +1,217 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncRefreshFailures(t *testing.T) {
+	clearCache()
+	var mu sync.Mutex
+	var failures []int
+	opts := NewDefaultOptions()
+	opts.AsyncRefreshMaxFailures = 2
+	opts.OnAsyncRefreshError = func(key string, n int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, rdbKey, key)
+		failures = append(failures, n)
+	}
+	rc := NewClient(rdb, opts)
+	errDB := errors.New("db down")
+	failFn := func() (string, error) { return "", errDB }
+
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	for i := 1; i <= 2; i++ {
+		v, err := rc.Fetch(rdbKey, 60*time.Second, failFn)
+		assert.Nil(t, err)
+		assert.Equal(t, "value1", v)
+		n := i
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(failures) == n
+		}, time.Second, 5*time.Millisecond)
+	}
+	mu.Lock()
+	assert.Equal(t, []int{1, 2}, failures)
+	mu.Unlock()
+
+	// the stale value is not returned any more, so the error surfaces
+	_, err = rc.Fetch(rdbKey, 60*time.Second, failFn)
+	assert.Equal(t, errDB, err)
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+	assert.Equal(t, 0, rc.refreshFailures.count(rdbKey))
+}
+
+func TestAsyncRefreshFailuresBatch(t *testing.T) {
+	clearCache()
+	var mu sync.Mutex
+	var failed = map[string]int{}
+	opts := NewDefaultOptions()
+	opts.AsyncRefreshMaxFailures = 1
+	opts.OnAsyncRefreshError = func(key string, n int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[key] = n
+	}
+	rc := NewClient(rdb, opts)
+	keys := genKeys(genIdxs(3))
+	errDB := errors.New("db down")
+	failFn := func(idxs []int) (map[int]string, error) { return nil, errDB }
+
+	_, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(3, "value_"), 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+	v, err := rc.FetchBatch(keys, 60*time.Second, failFn)
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(3, "value_"), v)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failed) == 3
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, map[string]int{keys[0]: 1, keys[1]: 1, keys[2]: 1}, failed)
+	mu.Unlock()
+
+	_, err = rc.FetchBatch(keys, 60*time.Second, failFn)
+	assert.Equal(t, errDB, err)
+	v, err = rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(3, "eulav_"), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(3, "eulav_"), v)
+}
+
+func TestAsyncRefreshDetached(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+
+	// the request is done before the async refresh stores the value
+	ctx, cancel := context.WithCancel(context.Background())
+	v, err := rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc("value2", 30))
+	cancel()
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	time.Sleep(60 * time.Millisecond)
+	v, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestAsyncRefreshTimeout(t *testing.T) {
+	clearCache()
+	errCh := make(chan error, 1)
+	opts := NewDefaultOptions()
+	opts.AsyncRefreshTimeout = 20 * time.Millisecond
+	opts.OnAsyncRefreshError = func(key string, failures int, err error) {
+		errCh <- err
+	}
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 50))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	assert.ErrorIs(t, <-errCh, context.DeadlineExceeded)
+}
+
+func TestAsyncRefreshDedup(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+
+	var mu sync.Mutex
+	calls := 0
+	slowFn := func() (string, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		return "value2", nil
+	}
+	for i := 0; i < 2; i++ {
+		// the lock of the refreshing key is reset by TagAsDeleted, but the key is refreshed once by this process
+		err = rc.TagAsDeleted(rdbKey)
+		assert.Nil(t, err)
+		v, err := rc.Fetch(rdbKey, 60*time.Second, slowFn)
+		assert.Nil(t, err)
+		assert.Equal(t, "value1", v)
+	}
+	time.Sleep(150 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 1, calls)
+	mu.Unlock()
+}
+
+func TestAsyncRefreshQueueFull(t *testing.T) {
+	for _, policy := range []RefreshFullPolicy{RefreshDrop, RefreshSync} {
+		clearCache()
+		opts := NewDefaultOptions()
+		opts.AsyncRefreshWorkers = 1
+		opts.AsyncRefreshFullPolicy = policy
+		rc := NewClient(rdb, opts)
+		keys := genKeys(genIdxs(2))
+		for _, key := range keys {
+			_, err := rc.Fetch(key, 60*time.Second, genDataFunc("value1", 0))
+			assert.Nil(t, err)
+		}
+		err := rc.TagAsDeletedBatch(keys)
+		assert.Nil(t, err)
+
+		// the only worker is busy refreshing keys[0]
+		v, err := rc.Fetch(keys[0], 60*time.Second, genDataFunc("value2", 100))
+		assert.Nil(t, err)
+		assert.Equal(t, "value1", v)
+		time.Sleep(10 * time.Millisecond)
+		v, err = rc.Fetch(keys[1], 60*time.Second, genDataFunc("value2", 0))
+		assert.Nil(t, err)
+		if policy == RefreshSync {
+			assert.Equal(t, "value2", v)
+		} else {
+			assert.Equal(t, "value1", v)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestAsyncRefreshQueueFullBatch(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.AsyncRefreshWorkers = 1
+	opts.AsyncRefreshFullPolicy = RefreshSync
+	rc := NewClient(rdb, opts)
+	keys := genKeys(genIdxs(4))
+	_, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(4, "value_"), 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeletedBatch(keys)
+	assert.Nil(t, err)
+
+	v, err := rc.FetchBatch(keys[:2], 60*time.Second, genBatchDataFunc(genValues(2, "eulav_"), 100))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(2, "value_"), v)
+	time.Sleep(10 * time.Millisecond)
+	v, err = rc.FetchBatch(keys[2:], 60*time.Second, genBatchDataFunc(genValues(2, "eulav_"), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(2, "eulav_"), v)
+	time.Sleep(100 * time.Millisecond)
+}
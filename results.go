@@ -0,0 +1,220 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrPanic is matched by the error of the indexes of a batch, when fn panics while fetching them
+var ErrPanic = errors.New("fn panicked")
+
+func panicError(r interface{}) error {
+	return fmt.Errorf("%w: %v", ErrPanic, r)
+}
+
+// BatchError is returned by fn of a batch, when some of the indexes fail while the others are fetched.
+// the values returned with it are stored in cache, and the failed indexes are handled as if fn failed for them only.
+// an index failed with ErrNotFound is cached as not found.
+type BatchError struct {
+	// Errs is the error of each failed index
+	Errs map[int]error
+}
+
+func (e *BatchError) Error() string {
+	idxs := sortedIdxs(e.Errs)
+	if len(idxs) == 0 {
+		return "batch failed"
+	}
+	return fmt.Sprintf("%d indexes of the batch failed, index %d: %v", len(idxs), idxs[0], e.Errs[idxs[0]])
+}
+
+func sortedIdxs(errs map[int]error) []int {
+	idxs := make([]int, 0, len(errs))
+	for idx := range errs {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+// BatchResult is the value or the error of an index of a batch fetched by FetchBatchResults
+type BatchResult struct {
+	Value string
+	// Err is the error of the index, Value is still valid if Err matches ErrStale or ErrLockLost
+	Err error
+}
+
+// failures collects the errors of the keys of a batch fetched by FetchBatchResults, instead of failing the whole batch
+type failures struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+type failuresKey struct{}
+
+func withFailures(ctx context.Context) (context.Context, *failures) {
+	f := &failures{errs: make(map[string]error)}
+	return context.WithValue(ctx, failuresKey{}, f), f
+}
+
+func failuresFromContext(ctx context.Context) *failures {
+	f, _ := ctx.Value(failuresKey{}).(*failures)
+	return f
+}
+
+// withoutFailures hides the failures and the streamer of the batch from fn, so that the batches fetched by fn fail as usual
+func withoutFailures(ctx context.Context) context.Context {
+	if failuresFromContext(ctx) != nil {
+		ctx = context.WithValue(ctx, failuresKey{}, (*failures)(nil))
+	}
+	if streamFromContext(ctx) != nil {
+		ctx = context.WithValue(ctx, streamerKey{}, (*streamer)(nil))
+	}
+	return ctx
+}
+
+// add records err for keys, and reports whether it is recorded. the whole batch should fail with err if it is not.
+func (f *failures) add(err error, keys ...string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		f.errs[key] = err
+	}
+	return true
+}
+
+func (f *failures) get(key string) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.errs[key]
+}
+
+// splitFailed returns the values returned by fn for idxs, and the failed indexes if fn returns a BatchError.
+// the indexes not found are marked, see markNotFound.
+func splitFailed(data map[int]string, idxs []int, err error) (map[int]string, map[int]error, error) {
+	var be *BatchError
+	if !errors.As(err, &be) {
+		data, err = markNotFound(data, idxs, err)
+		return data, nil, err
+	}
+	if data == nil {
+		data = make(map[int]string)
+	}
+	failed := make(map[int]error, len(be.Errs))
+	for idx, e := range be.Errs {
+		if errors.Is(e, ErrNotFound) {
+			data[idx] = notFoundValue
+			continue
+		}
+		delete(data, idx)
+		failed[idx] = e
+	}
+	return data, failed, nil
+}
+
+// failAll returns err as the error of each of idxs
+func failAll(idxs []int, err error) map[int]error {
+	errs := make(map[int]error, len(idxs))
+	for _, idx := range idxs {
+		errs[idx] = err
+	}
+	return errs
+}
+
+// failBatch releases the locks of keys[idxs] after fn failed with errs, the errors may be cached, see Options.CacheError.
+// the stale values are kept until they are too old to be returned, instead of expiring after LockExpire.
+// for FetchBatchResults, the errors or the stale values of the keys are recorded, and the stale values are returned.
+// otherwise the stale values are returned with a StaleError only if all of keys[idxs] have one, or the error of fn is returned.
+func (c *Client) failBatch(ctx context.Context, keys []string, idxs []int, owner string, stale map[int]*staleValue, errs map[int]error) (map[int]string, error) {
+	type unlocks struct {
+		err   error
+		keys  []string
+		keeps []time.Duration
+	}
+	var groups []*unlocks // the keys failed with the same message are unlocked together
+	var byMessage = make(map[string]*unlocks)
+	var keeps = make(map[int]time.Duration, len(idxs))
+	for _, idx := range idxs {
+		keeps[idx] = c.keepFor(stale[idx])
+		g := byMessage[errs[idx].Error()]
+		if g == nil {
+			g = &unlocks{err: errs[idx]}
+			byMessage[errs[idx].Error()] = g
+			groups = append(groups, g)
+		}
+		g.keys = append(g.keys, keys[idx])
+		g.keeps = append(g.keeps, keeps[idx])
+	}
+	for _, g := range groups {
+		c.unlockFailed(ctx, g.keys, g.keeps, owner, g.err)
+	}
+
+	f := failuresFromContext(ctx)
+	var values = make(map[int]string, len(idxs))
+	var staleIdxs []int
+	var age time.Duration
+	for _, idx := range idxs {
+		if keeps[idx] <= 0 {
+			if !f.add(errs[idx], keys[idx]) {
+				return nil, errs[idx]
+			}
+			continue
+		}
+		values[idx] = stale[idx].value
+		staleIdxs = append(staleIdxs, idx)
+		if a := time.Since(stale[idx].staleAt); a > age {
+			age = a
+		}
+	}
+	if len(staleIdxs) == 0 {
+		return values, nil
+	}
+	c.logger().Warn("batch fetch failed, stale values served", "keys", pickKeys(keys, staleIdxs), "age", age, "err", errs[staleIdxs[0]])
+	for _, idx := range staleIdxs {
+		a := time.Since(stale[idx].staleAt)
+		c.metrics().StaleIfError(keys[idx], a)
+		infoFromContext(ctx).outcome(keys[idx], OutcomeStale)
+		f.add(&StaleError{Err: errs[idx], Age: a}, keys[idx])
+	}
+	if f != nil {
+		return values, nil
+	}
+	return values, &StaleError{Err: errs[staleIdxs[0]], Age: age, Idxs: staleIdxs}
+}
+
+// FetchBatchResults is same with FetchBatchCtx, except that a failed index does not fail the whole batch.
+// the value or the error of each index is returned, such as when it is locked by another owner until ctx is done.
+// fn may return a *BatchError with the values, to report the indexes it fails to fetch.
+// if fn panics, the indexes it is fetching are unlocked, and their errors match ErrPanic.
+// the not found indexes are in the result with ErrNotFound, and the stale values are returned with a *StaleError.
+// an error is returned without the results only if the whole batch fails, such as when redis fails.
+func (c *Client) FetchBatchResults(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error)) (map[int]BatchResult, error) {
+	ctx, f := withFailures(ctx)
+	values, err := c.fetchKeys(ctx, keys, expire, fn)
+	if values == nil && err != nil {
+		return nil, err
+	}
+	results := make(map[int]BatchResult, len(keys))
+	for idx, key := range keys {
+		v, ok := values[idx]
+		ferr := f.get(key)
+		if !ok && ferr == nil {
+			continue
+		}
+		if v == notFoundValue {
+			v, ferr = "", ErrNotFound
+		}
+		results[idx] = BatchResult{Value: v, Err: ferr}
+	}
+	return results, nil
+}
@@ -0,0 +1,112 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchBatchResults(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(3))
+	calls := 0
+	fn := func(ctx context.Context, idxs []int) (map[int]string, error) {
+		calls++
+		return map[int]string{0: "value_0"}, &BatchError{Errs: map[int]error{1: errDBDown, 2: ErrNotFound}}
+	}
+	rs, err := rc.FetchBatchResults(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]BatchResult{
+		0: {Value: "value_0"},
+		1: {Err: errDBDown},
+		2: {Err: ErrNotFound},
+	}, rs)
+	// the failed key is unlocked, and fetched again
+	assert.Equal(t, "", rdb.HGet(ctx, keys[1], "lockOwner").Val())
+	rs, err = rc.FetchBatchResults(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, errDBDown, rs[1].Err)
+	assert.Equal(t, 2, calls)
+
+	// a BatchError fails the whole batch of FetchBatch
+	_, err = rc.FetchBatch(keys, 60*time.Second, func(idxs []int) (map[int]string, error) {
+		return fn(ctx, idxs)
+	})
+	assert.Equal(t, errDBDown, err)
+}
+
+func TestFetchBatchResultsWaitFailed(t *testing.T) {
+	for _, strong := range []bool{false, true} {
+		clearCache()
+		rc := NewClient(rdb, NewDefaultOptions())
+		rc.Options.StrongConsistency = strong
+		keys := genKeys(genIdxs(3))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			dc := NewClient(rdb, NewDefaultOptions())
+			_, _ = dc.Fetch(keys[1], 60*time.Second, genDataFunc("value_1", 200))
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		rs, err := rc.FetchBatchResults(tctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+			return genBatchDataFunc(genValues(3, "value_"), 0)(idxs)
+		})
+		cancel()
+		assert.Nil(t, err)
+		assert.Equal(t, BatchResult{Value: "value_0"}, rs[0])
+		assert.ErrorIs(t, rs[1].Err, context.DeadlineExceeded)
+		assert.Equal(t, BatchResult{Value: "value_2"}, rs[2])
+		<-done
+	}
+}
+
+func TestFetchBatchPanic(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	rc.Options.StrongConsistency = true
+	keys := genKeys(genIdxs(2))
+	panicFn := func(ctx context.Context, idxs []int) (map[int]string, error) {
+		panic("fetch panic")
+	}
+	rs, err := rc.FetchBatchResults(ctx, keys, 60*time.Second, panicFn)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, rs[0].Err, ErrPanic)
+	assert.ErrorIs(t, rs[1].Err, ErrPanic)
+
+	_, err = rc.FetchBatchCtx(ctx, keys, 60*time.Second, panicFn)
+	assert.ErrorIs(t, err, ErrPanic)
+
+	// the locks are released, so the keys are fetched without waiting
+	began := time.Now()
+	v, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(genValues(2, "value_"), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, genValues(2, "value_"), v)
+	assert.True(t, time.Since(began) < rc.Options.LockSleep)
+}
+
+func TestFetchBatchResultsStale(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.StaleIfError = time.Second
+	rc := NewClient(rdb, opts)
+	keys := genKeys(genIdxs(2))
+	_, err := rc.Fetch(keys[0], 60*time.Second, genDataFunc("value_0", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(keys[0])
+	assert.Nil(t, err)
+
+	rs, err := rc.FetchBatchResults(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		return nil, errDBDown
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "value_0", rs[0].Value)
+	assert.ErrorIs(t, rs[0].Err, ErrStale)
+	assert.ErrorIs(t, rs[0].Err, errDBDown)
+	assert.Equal(t, BatchResult{Err: errDBDown}, rs[1])
+}
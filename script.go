@@ -2,37 +2,101 @@ package rockscache
 
 import "github.com/redis/go-redis/v9"
 
+// luaNow sets now to the time of redis server in milliseconds, so that the clock skew between processes does not matter.
+// redis.replicate_commands is required by redis before 5.0 to write after TIME.
+//
+// the lock time is stored in milliseconds in lockUntilMs, and in seconds in lockUntil, which is used by older versions.
+// lockUntilMs is only trusted if lockUntil is not changed by older versions since it is written.
+//
+// notFound is set if the value is the not found result of fn, the value is empty for the older versions.
+//
+// error is the message of the error of fn cached until errorUntil in milliseconds, the key is not locked to fetch until then.
+// it is removed when a new value is stored or the key is tag deleted.
+//
+// staleAt is the time in milliseconds the value is tag deleted, it is removed when a new value is stored.
+// the values stored by older versions keep the staleAt of the former value, so they look older than they are,
+// and are not returned in StaleIfError mode for a shorter time.
+const luaNow = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local function getLockUntil(key)
+	local lu = redis.call('HGET', key, 'lockUntil')
+	if lu == false then
+		return false
+	end
+	local ms = redis.call('HGET', key, 'lockUntilMs')
+	if ms ~= false and math.ceil(tonumber(ms) / 1000) == tonumber(lu) then
+		return tonumber(ms)
+	end
+	return tonumber(lu) * 1000
+end
+local function setLockUntil(key, ms)
+	redis.call('HSET', key, 'lockUntil', math.ceil(ms / 1000))
+	redis.call('HSET', key, 'lockUntilMs', ms)
+end
+local function getStaleAge(key)
+	local sa = redis.call('HGET', key, 'staleAt')
+	return sa and tostring(now - tonumber(sa))
+end
+local function getCachedError(key)
+	local eu = redis.call('HGET', key, 'errorUntil')
+	if eu == false or tonumber(eu) <= now then
+		return false
+	end
+	return redis.call('HGET', key, 'error')
+end
+local function tagStale(key)
+	if redis.call('HEXISTS', key, 'staleAt') == 0 then
+		redis.call('HSET', key, 'staleAt', now)
+	end
+end
+`
+
 var (
-	deleteScript = redis.NewScript(`
+	deleteScript = redis.NewScript(luaNow + `
+tagStale(KEYS[1])
 redis.call('HSET', KEYS[1], 'lockUntil', 0)
-redis.call('HDEL', KEYS[1], 'lockOwner')
-redis.call('EXPIRE', KEYS[1], ARGV[1])`)
+redis.call('HDEL', KEYS[1], 'lockUntilMs', 'lockOwner', 'error', 'errorUntil')
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+if ARGV[2] ~= '' then
+	redis.call('PUBLISH', ARGV[2], KEYS[1])
+end`)
 
-	getScript = redis.NewScript(`
+	getScript = redis.NewScript(luaNow + `
 local v = redis.call('HGET', KEYS[1], 'value')
-local lu = redis.call('HGET', KEYS[1], 'lockUntil')
-if lu ~= false and tonumber(lu) < tonumber(ARGV[1]) or lu == false and v == false then
-	redis.call('HSET', KEYS[1], 'lockUntil', ARGV[2])
-	redis.call('HSET', KEYS[1], 'lockOwner', ARGV[3])
-	return { v, 'LOCKED' }
+local lu = getLockUntil(KEYS[1])
+local ce = getCachedError(KEYS[1])
+if ce == false and (lu ~= false and lu < now or lu == false and v == false) then
+	setLockUntil(KEYS[1], now + tonumber(ARGV[1]))
+	redis.call('HSET', KEYS[1], 'lockOwner', ARGV[2])
+	return { v, 'LOCKED', getStaleAge(KEYS[1]), redis.call('PTTL', KEYS[1]), redis.call('HEXISTS', KEYS[1], 'notFound') }
 end
-return {v, lu}`)
+return {v, lu and tostring(lu), getStaleAge(KEYS[1]), redis.call('PTTL', KEYS[1]), redis.call('HEXISTS', KEYS[1], 'notFound'), ce}`)
 
 	setScript = redis.NewScript(`
 local o = redis.call('HGET', KEYS[1], 'lockOwner')
 if o ~= ARGV[2] then
-		return
+	return o
 end
 redis.call('HSET', KEYS[1], 'value', ARGV[1])
-redis.call('HDEL', KEYS[1], 'lockUntil')
-redis.call('HDEL', KEYS[1], 'lockOwner')
-redis.call('EXPIRE', KEYS[1], ARGV[3])`)
+redis.call('HDEL', KEYS[1], 'lockUntil', 'lockUntilMs', 'lockOwner', 'staleAt', 'error', 'errorUntil')
+if ARGV[5] == '1' then
+	redis.call('HSET', KEYS[1], 'notFound', 1)
+else
+	redis.call('HDEL', KEYS[1], 'notFound')
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+if ARGV[4] ~= '' then
+	redis.call('PUBLISH', ARGV[4], KEYS[1])
+end`)
 
 	lockScript = redis.NewScript(`
 local lu = redis.call('HGET', KEYS[1], 'lockUntil')
 local lo = redis.call('HGET', KEYS[1], 'lockOwner')
 if lu == false or tonumber(lu) < tonumber(ARGV[2]) or lo == ARGV[1] then
 	redis.call('HSET', KEYS[1], 'lockUntil', ARGV[2])
+	redis.call('HDEL', KEYS[1], 'lockUntilMs')
 	redis.call('HSET', KEYS[1], 'lockOwner', ARGV[1])
 	return 'LOCKED'
 end
@@ -42,44 +106,107 @@ return lo`)
 local lo = redis.call('HGET', KEYS[1], 'lockOwner')
 if lo == ARGV[1] then
 	redis.call('HSET', KEYS[1], 'lockUntil', 0)
-	redis.call('HDEL', KEYS[1], 'lockOwner')
-	redis.call('EXPIRE', KEYS[1], ARGV[2])
+	redis.call('HDEL', KEYS[1], 'lockUntilMs', 'lockOwner')
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	if ARGV[3] ~= '' then
+		redis.call('PUBLISH', ARGV[3], KEYS[1])
+	end
 end`)
 
-	getBatchScript = redis.NewScript(`
+	getBatchScript = redis.NewScript(luaNow + `
 local rets = {}
 for i, key in ipairs(KEYS)
 do
 	local v = redis.call('HGET', key, 'value')
-	local lu = redis.call('HGET', key, 'lockUntil')
-	if lu ~= false and tonumber(lu) < tonumber(ARGV[1]) or lu == false and v == false then
-		redis.call('HSET', key, 'lockUntil', ARGV[2])
-		redis.call('HSET', key, 'lockOwner', ARGV[3])
-		table.insert(rets, { v, 'LOCKED' })
+	local lu = getLockUntil(key)
+	local ce = getCachedError(key)
+	if ce == false and (lu ~= false and lu < now or lu == false and v == false) then
+		setLockUntil(key, now + tonumber(ARGV[1]))
+		redis.call('HSET', key, 'lockOwner', ARGV[2])
+		table.insert(rets, { v, 'LOCKED', getStaleAge(key), redis.call('PTTL', key), redis.call('HEXISTS', key, 'notFound') })
 	else
-		table.insert(rets, {v, lu})
+		table.insert(rets, {v, lu and tostring(lu), getStaleAge(key), redis.call('PTTL', key), redis.call('HEXISTS', key, 'notFound'), ce})
 	end
 end
 return rets`)
 
 	setBatchScript = redis.NewScript(`
 local n = #KEYS
+local lost = {}
 for i, key in ipairs(KEYS)
 do
 	local o = redis.call('HGET', key, 'lockOwner')
 	if o ~= ARGV[1] then
-			return
+		if o then
+			table.insert(lost, key)
+		end
+	else
+		redis.call('HSET', key, 'value', ARGV[i+1])
+		redis.call('HDEL', key, 'lockUntil', 'lockUntilMs', 'lockOwner', 'staleAt', 'error', 'errorUntil')
+		if ARGV[2*n+2+i] == '1' then
+			redis.call('HSET', key, 'notFound', 1)
+		else
+			redis.call('HDEL', key, 'notFound')
+		end
+		redis.call('PEXPIRE', key, ARGV[i+1+n])
+		if ARGV[2*n+2] ~= '' then
+			redis.call('PUBLISH', ARGV[2*n+2], key)
+		end
+	end
+end
+return lost`)
+
+	renewScript = redis.NewScript(luaNow + `
+local lost = {}
+for i, key in ipairs(KEYS)
+do
+	if redis.call('HGET', key, 'lockOwner') == ARGV[1] then
+		setLockUntil(key, now + tonumber(ARGV[2]))
+	else
+		table.insert(lost, key)
+	end
+end
+return lost`)
+
+	cacheErrorScript = redis.NewScript(luaNow + `
+local n = #KEYS
+local errorExpire = tonumber(ARGV[3])
+for i, key in ipairs(KEYS)
+do
+	if redis.call('HGET', key, 'lockOwner') == ARGV[1] then
+		redis.call('HSET', key, 'lockUntil', 0)
+		redis.call('HDEL', key, 'lockUntilMs', 'lockOwner')
+		redis.call('HSET', key, 'error', ARGV[2])
+		redis.call('HSET', key, 'errorUntil', now + errorExpire)
+		redis.call('PEXPIRE', key, math.max(tonumber(ARGV[3+i]), errorExpire))
+		if ARGV[n+4] ~= '' then
+			redis.call('PUBLISH', ARGV[n+4], key)
+		end
 	end
-	redis.call('HSET', key, 'value', ARGV[i+1])
-	redis.call('HDEL', key, 'lockUntil')
-	redis.call('HDEL', key, 'lockOwner')
-	redis.call('EXPIRE', key, ARGV[i+1+n])
 end`)
 
-	deleteBatchScript = redis.NewScript(`
+	deleteBatchScript = redis.NewScript(luaNow + `
 for i, key in ipairs(KEYS) do
+	tagStale(key)
 	redis.call('HSET', key, 'lockUntil', 0)
-	redis.call('HDEL', key, 'lockOwner')
-	redis.call('EXPIRE', key, ARGV[1])
+	redis.call('HDEL', key, 'lockUntilMs', 'lockOwner', 'error', 'errorUntil')
+	redis.call('PEXPIRE', key, ARGV[1])
+	if ARGV[2] ~= '' then
+		redis.call('PUBLISH', ARGV[2], key)
+	end
 end`)
 )
+
+// scriptNames is the name of the scripts reported to Options.Metrics
+var scriptNames = map[*redis.Script]string{
+	deleteScript:      "delete",
+	getScript:         "get",
+	setScript:         "set",
+	lockScript:        "lock",
+	unlockScript:      "unlock",
+	getBatchScript:    "getBatch",
+	setBatchScript:    "setBatch",
+	renewScript:       "renew",
+	deleteBatchScript: "deleteBatch",
+	cacheErrorScript:  "cacheError",
+}
@@ -0,0 +1,130 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrStale is matched by the error returned with the stale values, when fn fails in StaleIfError mode
+var ErrStale = errors.New("stale value returned")
+
+// StaleError is returned with the last known values, when fn fails and the values were tag deleted less than StaleIfError ago.
+// it wraps the error of fn, and matches ErrStale.
+type StaleError struct {
+	// Err is the error of fn
+	Err error
+	// Age is the time since the value is tag deleted, the oldest one in a batch
+	Age time.Duration
+	// Idxs is the indexes of the stale values returned by a batch, it is nil for a single key
+	Idxs []int
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("%s, stale for %s: %v", ErrStale, e.Age, e.Err)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrStale
+func (e *StaleError) Is(target error) bool {
+	return target == ErrStale
+}
+
+// valuesReturned reports whether the values are returned with err, which is ErrLockLost or ErrStale
+func valuesReturned(err error) bool {
+	return errors.Is(err, ErrLockLost) || errors.Is(err, ErrStale)
+}
+
+// staleValue is the last known value of a key being fetched, and the local time it is tag deleted
+type staleValue struct {
+	value   string
+	staleAt time.Time
+}
+
+// staleOf returns the stale value in the result r of Store.Get, which may be returned if fn fails.
+// nil is returned if StaleIfError is 0, or the key has no value, or the value is not tag deleted.
+func (c *Client) staleOf(r []interface{}) *staleValue {
+	if c.Options.StaleIfError <= 0 || len(r) < 3 || r[0] == nil || r[2] == nil {
+		return nil
+	}
+	age, err := strconv.ParseInt(r[2].(string), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &staleValue{value: r[0].(string), staleAt: time.Now().Add(-time.Duration(age) * time.Millisecond)}
+}
+
+// keepFor returns how long the stale value may still be returned, zero or negative if it is too old
+func (c *Client) keepFor(s *staleValue) time.Duration {
+	if s == nil {
+		return 0
+	}
+	return c.Options.StaleIfError - time.Since(s.staleAt)
+}
+
+// unlockStale releases the lock of key after fn failed, and returns the stale value with a StaleError if it may be returned.
+// the stale value is kept until it is too old to be returned, instead of expiring after LockExpire.
+// the error of fn may be cached, see Options.CacheError.
+func (c *Client) unlockStale(ctx context.Context, key string, owner string, stale *staleValue, err error) (string, error) {
+	keep := c.keepFor(stale)
+	c.unlockFailed(ctx, []string{key}, []time.Duration{keep}, owner, err)
+	if keep <= 0 {
+		return "", err
+	}
+	infoFromContext(ctx).outcome(key, OutcomeStale)
+	age := time.Since(stale.staleAt)
+	c.logger().Warn("fetch failed, stale value served", "key", key, "age", age, "err", err)
+	c.metrics().StaleIfError(key, age)
+	return stale.value, &StaleError{Err: err, Age: age}
+}
+
+// deleteDelay is the expire time of the tag deleted keys, which are kept for StaleIfError if it is longer than Delay
+func (c *Client) deleteDelay() time.Duration {
+	if c.Options.StaleIfError > c.Options.Delay {
+		return c.Options.StaleIfError
+	}
+	return c.Options.Delay
+}
+
+// remapStale returns err with the stale indexes idx mapped to origIdxs[idx], if err is a StaleError
+func remapStale(err error, origIdxs []int) error {
+	var se *StaleError
+	if !errors.As(err, &se) {
+		return err
+	}
+	origErr := *se
+	origErr.Idxs = make([]int, len(se.Idxs))
+	for i, idx := range se.Idxs {
+		origErr.Idxs[i] = origIdxs[idx]
+	}
+	return &origErr
+}
+
+// joinReturned joins the errors returned with the values by the fetches of a batch,
+// the stale indexes are merged, and ErrStale is preferred to ErrLockLost, so that the stale values are known by the caller.
+func joinReturned(prev error, err error) error {
+	if prev == nil || err == nil {
+		if err == nil {
+			return prev
+		}
+		return err
+	}
+	var ps, s *StaleError
+	if !errors.As(prev, &ps) {
+		return err
+	}
+	if !errors.As(err, &s) {
+		return prev
+	}
+	joined := *ps
+	joined.Idxs = append(append([]int(nil), ps.Idxs...), s.Idxs...)
+	if s.Age > joined.Age {
+		joined.Age = s.Age
+	}
+	return &joined
+}
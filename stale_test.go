@@ -0,0 +1,133 @@
+package rockscache
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errDBDown = errors.New("db down")
+
+func failData() (string, error) { return "", errDBDown }
+
+func failBatchData(idxs []int) (map[int]string, error) { return nil, errDBDown }
+
+func TestStaleIfError(t *testing.T) {
+	clearCache()
+	m := &countMetrics{counts: map[string]int{}}
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.StaleIfError = time.Second
+	opts.Metrics = m
+	rc := NewClient(rdb, opts)
+
+	// no stale value
+	_, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+
+	_, err = rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		v, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+		assert.Equal(t, "value1", v)
+		assert.ErrorIs(t, err, ErrStale)
+		assert.ErrorIs(t, err, errDBDown)
+		var se *StaleError
+		assert.True(t, errors.As(err, &se))
+		assert.True(t, se.Age < time.Second)
+	}
+	assert.Equal(t, 2, m.get("staleIfError"))
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", v)
+}
+
+func TestStaleIfErrorTooOld(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.StaleIfError = 50 * time.Millisecond
+	rc := NewClient(rdb, opts)
+
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	time.Sleep(60 * time.Millisecond)
+	// the age is counted from the first TagAsDeleted
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Equal(t, errDBDown, err)
+	assert.Equal(t, "", v)
+}
+
+func TestStaleIfErrorAsyncKeepsValue(t *testing.T) {
+	clearCache()
+	errCh := make(chan error, 1)
+	opts := NewDefaultOptions()
+	opts.StaleIfError = 20 * time.Second
+	opts.OnAsyncRefreshError = func(key string, failures int, err error) {
+		errCh <- err
+	}
+	rc := NewClient(rdb, opts)
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	assert.True(t, rdb.PTTL(ctx, rdbKey).Val() > 10*time.Second)
+
+	v, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	// the error of fn is reported, and the stale value does not expire after LockExpire
+	assert.Equal(t, errDBDown, <-errCh)
+	assert.True(t, rdb.PTTL(ctx, rdbKey).Val() > 10*time.Second)
+}
+
+func TestStaleIfErrorBatch(t *testing.T) {
+	clearCache()
+	opts := NewDefaultOptions()
+	opts.StrongConsistency = true
+	opts.StaleIfError = time.Second
+	rc := NewClient(rdb, opts)
+	keys := genKeys(genIdxs(3))
+	values := genValues(3, "value_")
+	_, err := rc.FetchBatch(keys, 60*time.Second, genBatchDataFunc(values, 0))
+	assert.Nil(t, err)
+
+	err = rc.TagAsDeletedBatch(keys[:2])
+	assert.Nil(t, err)
+	v, err := rc.FetchBatch(keys, 60*time.Second, failBatchData)
+	assert.ErrorIs(t, err, ErrStale)
+	assert.ErrorIs(t, err, errDBDown)
+	assert.Equal(t, values, v)
+	var se *StaleError
+	assert.True(t, errors.As(err, &se))
+	sort.Ints(se.Idxs)
+	assert.Equal(t, []int{0, 1}, se.Idxs)
+
+	// a key without stale value fails the batch
+	keys = append(keys, "key_no_value")
+	_, err = rc.FetchBatch(keys, 60*time.Second, failBatchData)
+	assert.Equal(t, errDBDown, err)
+}
+
+func TestStaleIfErrorMemory(t *testing.T) {
+	rc := newMemoryClient()
+	rc.Options.StrongConsistency = true
+	rc.Options.StaleIfError = time.Second
+	_, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted(rdbKey)
+	assert.Nil(t, err)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, failData)
+	assert.ErrorIs(t, err, ErrStale)
+	assert.Equal(t, "value1", v)
+}
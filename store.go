@@ -0,0 +1,239 @@
+package rockscache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is the storage backend of rockscache.
+// for each key, it keeps a value, the time the lock is released, and the owner of the lock.
+// all the operations on a key should be atomic, and the operations on the keys of a batch are atomic for each key.
+// the values are opaque strings, including the reserved value which marks the not found result of fn.
+type Store interface {
+	// Get returns the values and the locks of keys, each result is a []interface{}{value, lock, staleAge, ttl, error}:
+	// value is nil if the key has no value, otherwise a string.
+	// if the lock is expired or tag deleted, or the key has neither value nor lock, and no error is cached,
+	// the key is locked by owner for lockExpire, and lock is "LOCKED".
+	// otherwise lock is the time the lock is released in milliseconds as a string if locked by other, or nil if not locked.
+	// staleAge is the time since the key is first tag deleted in milliseconds as a string,
+	// or nil if a value is stored after that.
+	// ttl is the remaining time to live of the key in milliseconds as an int64, or a negative number if it does not expire.
+	// error is the message of the error cached by CacheError as a string, or nil if not cached or expired.
+	Get(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]interface{}, error)
+	// Set stores the values of the keys locked by owner, releases the lock, and sets the expire time of the keys.
+	// it returns the keys locked by other owners, which are not stored.
+	Set(ctx context.Context, keys []string, values []string, expires []time.Duration, owner string) ([]string, error)
+	// CacheError releases the locks of keys locked by owner, and caches the error message for errorExpire,
+	// the keys expire after the longer of expires and errorExpire. the error is removed by Set and TagAsDeleted.
+	CacheError(ctx context.Context, keys []string, expires []time.Duration, owner string, message string, errorExpire time.Duration) error
+	// Renew extends the lock of the keys locked by owner for lockExpire, and returns the keys not locked by owner any more.
+	Renew(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]string, error)
+	// Lock locks key by owner until unlocked, if it is not locked by another owner until unlocked.
+	// it returns "LOCKED" if locked, otherwise the owner of the lock.
+	Lock(ctx context.Context, key string, owner string) (string, error)
+	// Unlock releases the lock of key if locked by owner, the value is stale and expires after expire.
+	Unlock(ctx context.Context, key string, owner string, expire time.Duration) error
+	// TagAsDeleted releases the lock of keys, the values are stale and expire after delay.
+	// the time a key is tag deleted is kept until a new value is stored, so that it is not reset by the next TagAsDeleted.
+	TagAsDeleted(ctx context.Context, keys []string, delay time.Duration) error
+	// RawGet returns the value of key no matter if the key is locked or not, redis.Nil is returned if not exists.
+	RawGet(ctx context.Context, key string) (string, error)
+	// RawSet sets the value of key no matter if the key is locked or not.
+	RawSet(ctx context.Context, key string, value string, expire time.Duration) error
+	// Del deletes key.
+	Del(ctx context.Context, key string) error
+}
+
+// redisStore stores the keys in redis hash, and operates them by lua scripts.
+// opts is the options of the client, for the pub/sub channels and the replicas to wait.
+// in cluster mode, the keys of a batch are operated by one script per hash slot.
+// the keys of a batch are split into chunks of at most ScriptBatchSize keys, which are sent in one pipeline.
+type redisStore struct {
+	rdb     redis.UniversalClient
+	opts    *Options
+	cluster bool
+}
+
+func newRedisStore(rdb redis.UniversalClient, opts *Options) *redisStore {
+	_, cluster := rdb.(*redis.ClusterClient)
+	return &redisStore{rdb: rdb, opts: opts, cluster: cluster}
+}
+
+// call calls the script, and reports the latency to Options.Metrics
+func (s *redisStore) call(ctx context.Context, script *redis.Script, keys []string, args []interface{}) (interface{}, error) {
+	began := time.Now()
+	res, err := callLua(ctx, s.rdb, script, keys, args, s.opts.logger())
+	if s.opts.Metrics != nil {
+		s.opts.Metrics.Script(scriptNames[script], time.Since(began), err)
+	}
+	return res, err
+}
+
+func (s *redisStore) Get(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]interface{}, error) {
+	if len(keys) == 1 {
+		res, err := s.call(ctx, getScript, keys, []interface{}{lockExpire.Milliseconds(), owner})
+		s.opts.logger().Debug("luaGet return", "result", res, "err", err)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{fromStored(res)}, nil
+	}
+	rets := make([]interface{}, len(keys))
+	err := s.callChunks(ctx, getBatchScript, keys, func(idxs []int) []interface{} {
+		return []interface{}{lockExpire.Milliseconds(), owner}
+	}, func(idxs []int, res interface{}) error {
+		s.opts.logger().Debug("luaGetBatch return", "result", res)
+		for i, r := range res.([]interface{}) {
+			rets[idxs[i]] = fromStored(r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rets, nil
+}
+
+func (s *redisStore) Set(ctx context.Context, keys []string, values []string, expires []time.Duration, owner string) ([]string, error) {
+	if len(keys) == 1 {
+		value, notFound := toStored(values[0])
+		res, err := s.call(ctx, setScript, keys, []interface{}{value, owner, expires[0].Milliseconds(), s.opts.NotifyChannel, notFound})
+		if err == nil && res != nil { // locked by another owner
+			return keys, nil
+		}
+		return nil, err
+	}
+	var lost []string
+	err := s.callChunks(ctx, setBatchScript, keys, func(idxs []int) []interface{} {
+		var vals = make([]interface{}, 0, 2+3*len(idxs))
+		var flags = make([]interface{}, 0, len(idxs))
+		vals = append(vals, owner)
+		for _, idx := range idxs {
+			value, notFound := toStored(values[idx])
+			vals = append(vals, value)
+			flags = append(flags, notFound)
+		}
+		for _, idx := range idxs {
+			vals = append(vals, expires[idx].Milliseconds())
+		}
+		vals = append(vals, s.opts.NotifyChannel)
+		return append(vals, flags...)
+	}, func(idxs []int, res interface{}) error {
+		lost = append(lost, toStrings(res)...)
+		return nil
+	})
+	return lost, err
+}
+
+// toStored returns the value stored in the hash, and the notFound flag
+func toStored(value string) (string, string) {
+	if value == notFoundValue {
+		return "", "1"
+	}
+	return value, ""
+}
+
+// fromStored converts the result of the get scripts {value, lock, staleAge, ttl, notFound, error}
+// to the result of Store.Get, the value of a not found result is the reserved value.
+func fromStored(res interface{}) interface{} {
+	r, _ := res.([]interface{})
+	for len(r) < 6 { // the trailing nil of the result is dropped
+		r = append(r, nil)
+	}
+	if r[0] != nil && r[4] == int64(1) {
+		r[0] = notFoundValue
+	}
+	return []interface{}{r[0], r[1], r[2], r[3], r[5]}
+}
+
+func (s *redisStore) CacheError(ctx context.Context, keys []string, expires []time.Duration, owner string, message string, errorExpire time.Duration) error {
+	return s.callChunks(ctx, cacheErrorScript, keys, func(idxs []int) []interface{} {
+		var args = make([]interface{}, 0, 4+len(idxs))
+		args = append(args, owner, message, errorExpire.Milliseconds())
+		for _, idx := range idxs {
+			args = append(args, expires[idx].Milliseconds())
+		}
+		return append(args, s.opts.NotifyChannel)
+	}, func(idxs []int, res interface{}) error {
+		return nil
+	})
+}
+
+func (s *redisStore) Renew(ctx context.Context, keys []string, lockExpire time.Duration, owner string) ([]string, error) {
+	var lost []string
+	err := s.callChunks(ctx, renewScript, keys, func(idxs []int) []interface{} {
+		return []interface{}{owner, lockExpire.Milliseconds()}
+	}, func(idxs []int, res interface{}) error {
+		s.opts.logger().Debug("luaRenew return", "result", res)
+		lost = append(lost, toStrings(res)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lost, nil
+}
+
+func (s *redisStore) Lock(ctx context.Context, key string, owner string) (string, error) {
+	lockUntil := math.Pow10(10)
+	res, err := s.call(ctx, lockScript, []string{key}, []interface{}{owner, lockUntil})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(res), nil
+}
+
+func (s *redisStore) Unlock(ctx context.Context, key string, owner string, expire time.Duration) error {
+	_, err := s.call(ctx, unlockScript, []string{key}, []interface{}{owner, expire.Milliseconds(), s.opts.NotifyChannel})
+	return err
+}
+
+func (s *redisStore) TagAsDeleted(ctx context.Context, keys []string, delay time.Duration) error {
+	args := []interface{}{delay.Milliseconds(), s.opts.InvalidationChannel}
+	var err error
+	if len(keys) == 1 {
+		_, err = s.call(ctx, deleteScript, keys, args)
+	} else {
+		err = s.callChunks(ctx, deleteBatchScript, keys, func(idxs []int) []interface{} {
+			return args
+		}, func(idxs []int, res interface{}) error {
+			return nil
+		})
+	}
+	if err != nil || s.opts.WaitReplicas <= 0 {
+		return err
+	}
+	cmd := redis.NewCmd(ctx, "WAIT", s.opts.WaitReplicas, s.opts.WaitReplicasTimeout)
+	err = s.rdb.Process(ctx, cmd)
+	var replicas int
+	if err == nil {
+		replicas, err = cmd.Int()
+	}
+	if err == nil && replicas < s.opts.WaitReplicas {
+		err = fmt.Errorf("wait replicas %d failed. result replicas: %d", s.opts.WaitReplicas, replicas)
+	}
+	return err
+}
+
+func (s *redisStore) RawGet(ctx context.Context, key string) (string, error) {
+	return s.rdb.HGet(ctx, key, "value").Result()
+}
+
+func (s *redisStore) RawSet(ctx context.Context, key string, value string, expire time.Duration) error {
+	err := s.rdb.HSet(ctx, key, "value", value).Err()
+	if err == nil {
+		err = s.rdb.HDel(ctx, key, "notFound").Err()
+	}
+	if err == nil {
+		err = s.rdb.PExpire(ctx, key, expire).Err()
+	}
+	return err
+}
+
+func (s *redisStore) Del(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, key).Err()
+}
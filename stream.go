@@ -0,0 +1,112 @@
+package rockscache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// streamer delivers the result of each key of a batch fetched by FetchBatchStream, as soon as it is known
+type streamer struct {
+	mu        sync.Mutex
+	idxs      map[string][]int // the indexes of each key, a key may be duplicated in the batch
+	delivered map[int]bool
+	deliver   func(idx int, r BatchResult)
+}
+
+type streamerKey struct{}
+
+func withStreamer(ctx context.Context, keys []string, deliver func(idx int, r BatchResult)) (context.Context, *streamer) {
+	s := &streamer{idxs: make(map[string][]int, len(keys)), delivered: make(map[int]bool, len(keys)), deliver: deliver}
+	for idx, key := range keys {
+		s.idxs[key] = append(s.idxs[key], idx)
+	}
+	return context.WithValue(ctx, streamerKey{}, s), s
+}
+
+func streamFromContext(ctx context.Context) *streamer {
+	s, _ := ctx.Value(streamerKey{}).(*streamer)
+	return s
+}
+
+// send delivers the value or the error of key to its indexes not delivered yet, if s is not nil
+func (s *streamer) send(key string, value string, err error) {
+	if s == nil {
+		return
+	}
+	if value == notFoundValue {
+		value, err = "", ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, idx := range s.idxs[key] {
+		if !s.delivered[idx] {
+			s.delivered[idx] = true
+			s.deliver(idx, BatchResult{Value: value, Err: err})
+		}
+	}
+}
+
+// sendServed sends the stale values of keys[idxs] returned while refreshing, except syncIdxs, which are fetched synchronously
+func (s *streamer) sendServed(keys []string, idxs []int, syncIdxs []int, values map[int]string) {
+	if s == nil {
+		return
+	}
+	var toSync = make(map[int]bool, len(syncIdxs))
+	for _, idx := range syncIdxs {
+		toSync[idx] = true
+	}
+	for _, idx := range idxs {
+		if !toSync[idx] {
+			s.send(keys[idx], values[idx], nil)
+		}
+	}
+}
+
+// sendFetched sends the values fetched for keys[idxs], or the errors recorded for them
+func (s *streamer) sendFetched(ctx context.Context, keys []string, idxs []int, values map[int]string) {
+	if s == nil {
+		return
+	}
+	f := failuresFromContext(ctx)
+	for _, idx := range idxs {
+		v, ok := values[idx]
+		if err := f.get(keys[idx]); ok || err != nil {
+			s.send(keys[idx], v, err)
+		}
+	}
+}
+
+// finish delivers the results of the indexes not delivered yet
+func (s *streamer) finish(results map[int]BatchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idxs := make([]int, 0, len(results))
+	for idx := range results {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		if !s.delivered[idx] {
+			s.delivered[idx] = true
+			s.deliver(idx, results[idx])
+		}
+	}
+}
+
+// FetchBatchStream is same with FetchBatchResults, except that the result of each index is delivered by deliver as soon as it is known,
+// instead of after the whole batch is done. the values in cache are delivered right after they are read,
+// the values fetched by fn are delivered when each chunk of LoaderBatchSize is fetched,
+// and the indexes locked by other owners are delivered when they are stored, or fail when ctx is done.
+// deliver is called once for each index in the result of FetchBatchResults, one call at a time, and should not block.
+// if the whole batch fails, such as when redis fails, the error is returned, and the indexes not delivered yet are not.
+func (c *Client) FetchBatchStream(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, error), deliver func(idx int, r BatchResult)) error {
+	ctx, s := withStreamer(ctx, keys, deliver)
+	results, err := c.FetchBatchResults(ctx, keys, expire, fn)
+	if err != nil {
+		return err
+	}
+	s.finish(results)
+	return nil
+}
@@ -0,0 +1,133 @@
+package rockscache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// streamRecorder records the results delivered by FetchBatchStream
+type streamRecorder struct {
+	mu      sync.Mutex
+	results map[int]BatchResult
+	calls   int
+}
+
+func (r *streamRecorder) deliver(idx int, res BatchResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	r.results[idx] = res
+}
+
+func (r *streamRecorder) get(idx int) (BatchResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.results[idx]
+	return res, ok
+}
+
+func TestFetchBatchStream(t *testing.T) {
+	for _, strong := range []bool{false, true} {
+		clearCache()
+		rc := NewClient(rdb, NewDefaultOptions())
+		rc.Options.StrongConsistency = strong
+		keys := genKeys(genIdxs(4))
+		_, err := rc.Fetch(keys[0], 60*time.Second, genDataFunc("value_0", 0))
+		assert.Nil(t, err)
+
+		rec := &streamRecorder{results: make(map[int]BatchResult)}
+		err = rc.FetchBatchStream(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+			// the value in cache is delivered before fn is called
+			res, ok := rec.get(0)
+			assert.True(t, ok)
+			assert.Equal(t, BatchResult{Value: "value_0"}, res)
+			return map[int]string{1: "value_1"}, &BatchError{Errs: map[int]error{2: errDBDown, 3: ErrNotFound}}
+		}, rec.deliver)
+		assert.Nil(t, err)
+		assert.Equal(t, map[int]BatchResult{
+			0: {Value: "value_0"},
+			1: {Value: "value_1"},
+			2: {Err: errDBDown},
+			3: {Err: ErrNotFound},
+		}, rec.results)
+		assert.Equal(t, 4, rec.calls)
+	}
+}
+
+func TestFetchBatchStreamChunks(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	rc.Options.LoaderBatchSize = 2
+	keys := genKeys(genIdxs(4))
+	values := genValues(4, "value_")
+	rec := &streamRecorder{results: make(map[int]BatchResult)}
+	err := rc.FetchBatchStream(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		if idxs[0] == 2 {
+			// the first chunk is delivered before the second one is fetched
+			_, ok := rec.get(0)
+			assert.True(t, ok)
+			_, ok = rec.get(1)
+			assert.True(t, ok)
+		}
+		return genBatchDataFunc(values, 0)(idxs)
+	}, rec.deliver)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rec.results))
+	for i, v := range values {
+		assert.Equal(t, BatchResult{Value: v}, rec.results[i])
+	}
+}
+
+func TestFetchBatchStreamWait(t *testing.T) {
+	for _, strong := range []bool{false, true} {
+		clearCache()
+		rc := NewClient(rdb, NewDefaultOptions())
+		rc.Options.StrongConsistency = strong
+		keys := genKeys(genIdxs(3))
+		var wg sync.WaitGroup
+		for i, delay := range []int{50, 500} {
+			wg.Add(1)
+			go func(key string, value string, delay int) {
+				defer wg.Done()
+				dc := NewClient(rdb, NewDefaultOptions())
+				_, _ = dc.Fetch(key, 60*time.Second, genDataFunc(value, delay))
+			}(keys[i+1], fmt.Sprintf("value_%d", i+1), delay)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		rec := &streamRecorder{results: make(map[int]BatchResult)}
+		tctx, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
+		err := rc.FetchBatchStream(tctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+			return genBatchDataFunc(genValues(3, "value_"), 0)(idxs)
+		}, func(idx int, r BatchResult) {
+			if idx == 1 { // delivered when stored by the other, before the straggler fails
+				assert.Nil(t, tctx.Err())
+			}
+			rec.deliver(idx, r)
+		})
+		cancel()
+		assert.Nil(t, err)
+		assert.Equal(t, BatchResult{Value: "value_0"}, rec.results[0])
+		assert.Equal(t, BatchResult{Value: "value_1"}, rec.results[1])
+		assert.ErrorIs(t, rec.results[2].Err, context.DeadlineExceeded)
+		wg.Wait()
+	}
+}
+
+func TestFetchBatchStreamDuplicated(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := []string{"key_0", "key_1", "key_0"}
+	rec := &streamRecorder{results: make(map[int]BatchResult)}
+	err := rc.FetchBatchStream(ctx, keys, 60*time.Second, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		return map[int]string{0: "value_0", 1: "value_1", 2: "value_0"}, nil
+	}, rec.deliver)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rec.calls)
+	assert.Equal(t, BatchResult{Value: "value_0"}, rec.results[2])
+}
@@ -0,0 +1,116 @@
+package rockscache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dtm-labs/rockscache"
+
+// spanStats counts the outcome of the keys and the lock waits of a Fetch or FetchBatch span
+type spanStats struct {
+	hits      int64
+	misses    int64
+	stale     int64
+	lockWaits int64
+}
+
+type spanStatsKey struct{}
+
+func (c *Client) tracer() trace.Tracer {
+	tp := c.Options.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+func (c *Client) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return c.tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startFetchSpan starts a span which counts the outcome of the keys and the lock waits
+func (c *Client) startFetchSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span, *spanStats) {
+	ctx, span := c.startSpan(ctx, name, attrs...)
+	if !span.IsRecording() {
+		return ctx, span, nil
+	}
+	stats := &spanStats{}
+	return context.WithValue(ctx, spanStatsKey{}, stats), span, stats
+}
+
+func statsFromContext(ctx context.Context) *spanStats {
+	stats, _ := ctx.Value(spanStatsKey{}).(*spanStats)
+	return stats
+}
+
+func (s *spanStats) hit() {
+	if s != nil {
+		atomic.AddInt64(&s.hits, 1)
+	}
+}
+
+func (s *spanStats) miss() {
+	if s != nil {
+		atomic.AddInt64(&s.misses, 1)
+	}
+}
+
+func (s *spanStats) staleServed() {
+	if s != nil {
+		atomic.AddInt64(&s.stale, 1)
+	}
+}
+
+func (s *spanStats) lockWait() {
+	if s != nil {
+		atomic.AddInt64(&s.lockWaits, 1)
+	}
+}
+
+// attributes returns the outcome and the lock waits, batch is true for FetchBatch
+func (s *spanStats) attributes(batch bool) []attribute.KeyValue {
+	hits, misses, stale := atomic.LoadInt64(&s.hits), atomic.LoadInt64(&s.misses), atomic.LoadInt64(&s.stale)
+	attrs := []attribute.KeyValue{attribute.Int64("rockscache.lock_waits", atomic.LoadInt64(&s.lockWaits))}
+	if batch {
+		return append(attrs, attribute.Int64("rockscache.hits", hits),
+			attribute.Int64("rockscache.misses", misses), attribute.Int64("rockscache.stale", stale))
+	}
+	outcome := "hit"
+	if misses > 0 {
+		outcome = "miss"
+	} else if stale > 0 {
+		outcome = "stale"
+	} else if hits == 0 {
+		outcome = "none"
+	}
+	return append(attrs, attribute.String("rockscache.outcome", outcome))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endFetchSpan ends the span started by startFetchSpan
+func endFetchSpan(span trace.Span, stats *spanStats, batch bool, err error) {
+	if stats != nil {
+		span.SetAttributes(stats.attributes(batch)...)
+	}
+	endSpan(span, err)
+}
+
+// startAsyncSpan starts the span of an async refresh, which is a new root linked to the span in ctx,
+// since the async refresh may last longer than the request.
+func (c *Client) startAsyncSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return c.tracer().Start(ctx, name, trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)), trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,110 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanByName(spans []sdktrace.ReadOnlySpan, name string) []sdktrace.ReadOnlySpan {
+	var found []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	clearCache()
+	sr := tracetest.NewSpanRecorder()
+	opts := NewDefaultOptions()
+	opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	rc := NewClient(rdb, opts)
+	ctx := context.Background()
+
+	_, err := rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc("value1", 0))
+	assert.Nil(t, err)
+	err = rc.TagAsDeleted2(ctx, rdbKey)
+	assert.Nil(t, err)
+	_, err = rc.Fetch2(ctx, rdbKey, 60*time.Second, genDataFunc("value2", 0))
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	keys := genKeys(genIdxs(3))
+	_, err = rc.FetchBatch2(ctx, keys, 60*time.Second, genBatchDataFunc(genValues(3, "value_"), 0))
+	assert.Nil(t, err)
+	err = rc.LockForUpdate(ctx, rdbKey, "owner1")
+	assert.Nil(t, err)
+
+	spans := sr.Ended()
+	fetches := spanByName(spans, "rockscache.Fetch")
+	assert.Equal(t, 2, len(fetches))
+	assert.Equal(t, "miss", spanAttr(fetches[0], "rockscache.outcome").AsString())
+	assert.Equal(t, "stale", spanAttr(fetches[1], "rockscache.outcome").AsString())
+	assert.Equal(t, rdbKey, spanAttr(fetches[0], "rockscache.key").AsString())
+
+	// the value is fetched in the trace of the first Fetch
+	fetchNews := spanByName(spans, "rockscache.fetchNew")
+	assert.Equal(t, 2, len(fetchNews))
+	assert.Equal(t, fetches[0].SpanContext().TraceID(), fetchNews[0].SpanContext().TraceID())
+	assert.NotEqual(t, "", spanAttr(fetchNews[0], "rockscache.owner").AsString())
+
+	// the async refresh is a new trace linked to the second Fetch
+	refreshes := spanByName(spans, "rockscache.asyncRefresh")
+	assert.Equal(t, 1, len(refreshes))
+	assert.NotEqual(t, fetches[1].SpanContext().TraceID(), refreshes[0].SpanContext().TraceID())
+	assert.Equal(t, 1, len(refreshes[0].Links()))
+	assert.Equal(t, fetches[1].SpanContext().SpanID(), refreshes[0].Links()[0].SpanContext.SpanID())
+
+	batches := spanByName(spans, "rockscache.FetchBatch")
+	assert.Equal(t, 1, len(batches))
+	assert.Equal(t, int64(3), spanAttr(batches[0], "rockscache.batch_size").AsInt64())
+	assert.Equal(t, int64(3), spanAttr(batches[0], "rockscache.misses").AsInt64())
+
+	assert.Equal(t, 1, len(spanByName(spans, "rockscache.TagAsDeleted")))
+	locks := spanByName(spans, "rockscache.LockForUpdate")
+	assert.Equal(t, 1, len(locks))
+	assert.Equal(t, "owner1", spanAttr(locks[0], "rockscache.owner").AsString())
+}
+
+func TestTracingLockWaits(t *testing.T) {
+	clearCache()
+	sr := tracetest.NewSpanRecorder()
+	opts := NewDefaultOptions()
+	opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	opts.LockSleep = 10 * time.Millisecond
+	rc := NewClient(rdb, opts)
+	rc2 := NewClient(rdb, opts)
+
+	go func() {
+		_, _ = rc2.Fetch(rdbKey, 60*time.Second, genDataFunc("value1", 100))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	v, err := rc.Fetch(rdbKey, 60*time.Second, genDataFunc("ignored", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+
+	var waited bool
+	for _, s := range spanByName(sr.Ended(), "rockscache.Fetch") {
+		if spanAttr(s, "rockscache.lock_waits").AsInt64() > 0 {
+			waited = true
+			assert.Equal(t, "hit", spanAttr(s, "rockscache.outcome").AsString())
+		}
+	}
+	assert.True(t, waited)
+}
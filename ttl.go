@@ -0,0 +1,77 @@
+package rockscache
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ttlRecorder collects the TTLs returned by the loader of FetchWithTTL or FetchBatchWithTTL, indexed by the indexes of keys.
+// the index of a single key is 0.
+type ttlRecorder struct {
+	mu   sync.Mutex
+	ttls map[int]time.Duration
+}
+
+type ttlRecorderKey struct{}
+
+func withTTLRecorder(ctx context.Context) (context.Context, *ttlRecorder) {
+	rec := &ttlRecorder{ttls: make(map[int]time.Duration)}
+	return context.WithValue(ctx, ttlRecorderKey{}, rec), rec
+}
+
+func ttlFromContext(ctx context.Context) *ttlRecorder {
+	rec, _ := ctx.Value(ttlRecorderKey{}).(*ttlRecorder)
+	return rec
+}
+
+func (r *ttlRecorder) set(ttls map[int]time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, ttl := range ttls {
+		r.ttls[idx] = ttl
+	}
+}
+
+// expire returns the TTL returned by the loader for idx if > 0, otherwise expire
+func (r *ttlRecorder) expire(idx int, expire time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ttl := r.ttls[idx]; ttl > 0 {
+		return ttl
+	}
+	return expire
+}
+
+// expireFor returns the time to store a value expiring after expire, which is reduced by Delay and RandomExpireAdjustment
+func (c *Client) expireFor(expire time.Duration) time.Duration {
+	return expire - c.Options.Delay - time.Duration(rand.Float64()*c.Options.RandomExpireAdjustment*float64(expire))
+}
+
+// FetchWithTTL is same with FetchCtx, except that fn returns the expire time of its value too, which is used instead of expire.
+// expire is used if the returned ttl is not positive. the ttl is still reduced by Delay and RandomExpireAdjustment,
+// and empty or not found values are cached for EmptyExpire or NotFoundExpire.
+func (c *Client) FetchWithTTL(ctx context.Context, key string, expire time.Duration, fn func(ctx context.Context) (string, time.Duration, error)) (string, error) {
+	info, err := c.fetch(ctx, key, expire, func(ctx context.Context) (string, error) {
+		v, ttl, err := fn(ctx)
+		ttlFromContext(ctx).set(map[int]time.Duration{0: ttl})
+		return v, err
+	})
+	return info.Value, err
+}
+
+// FetchBatchWithTTL is same with FetchBatchCtx, except that fn returns the expire times of its values too, indexed by the indexes of keys.
+// expire is used for the indexes without a positive ttl. the ttls are still reduced by Delay and RandomExpireAdjustment,
+// and empty or not found values are cached for EmptyExpire or NotFoundExpire.
+func (c *Client) FetchBatchWithTTL(ctx context.Context, keys []string, expire time.Duration, fn func(ctx context.Context, idxs []int) (map[int]string, map[int]time.Duration, error)) (map[int]string, error) {
+	result, err := c.fetchKeys(ctx, keys, expire, func(ctx context.Context, idxs []int) (map[int]string, error) {
+		values, ttls, err := fn(ctx, idxs)
+		ttlFromContext(ctx).set(ttls)
+		return values, err
+	})
+	return dropNotFound(result), err
+}
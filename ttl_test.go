@@ -0,0 +1,51 @@
+package rockscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchWithTTL(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	v, err := rc.FetchWithTTL(ctx, rdbKey, 60*time.Second, func(ctx context.Context) (string, time.Duration, error) {
+		return "draft", 30 * time.Second, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "draft", v)
+	// the ttl is reduced by Delay and RandomExpireAdjustment
+	ttl := rdb.PTTL(ctx, rdbKey).Val()
+	assert.True(t, ttl > 16*time.Second && ttl <= 20*time.Second, ttl)
+
+	// expire is used without a ttl
+	rc.Options.StrongConsistency = true
+	v, err = rc.FetchWithTTL(ctx, "key2", 60*time.Second, func(ctx context.Context) (string, time.Duration, error) {
+		return "published", 0, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "published", v)
+	ttl = rdb.PTTL(ctx, "key2").Val()
+	assert.True(t, ttl > 40*time.Second && ttl <= 50*time.Second, ttl)
+}
+
+func TestFetchBatchWithTTL(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(3))
+	fn := func(ctx context.Context, idxs []int) (map[int]string, map[int]time.Duration, error) {
+		return map[int]string{0: "draft", 1: "published", 2: ""}, map[int]time.Duration{0: 30 * time.Second, 2: time.Hour}, nil
+	}
+	v, err := rc.FetchBatchWithTTL(ctx, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{0: "draft", 1: "published", 2: ""}, v)
+	ttl := rdb.PTTL(ctx, keys[0]).Val()
+	assert.True(t, ttl > 16*time.Second && ttl <= 20*time.Second, ttl)
+	ttl = rdb.PTTL(ctx, keys[1]).Val()
+	assert.True(t, ttl > 40*time.Second && ttl <= 50*time.Second, ttl)
+	// empty values are cached for EmptyExpire
+	ttl = rdb.PTTL(ctx, keys[2]).Val()
+	assert.True(t, ttl > 0 && ttl <= rc.Options.EmptyExpire, ttl)
+}
@@ -0,0 +1,103 @@
+package rockscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// typedPrefix is prepended to the encoded values, so that a value encoded to no bytes,
+// such as a protobuf message with default values, is not taken as the empty result
+const typedPrefix = "="
+
+func (c *Client) codec() Codec {
+	if c.Options.Codec == nil {
+		return JSONCodec{}
+	}
+	return c.Options.Codec
+}
+
+func encodeValue[T any](codec Codec, v T) (string, error) {
+	if codec.IsEmpty(v) {
+		return "", nil
+	}
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return typedPrefix + string(b), nil
+}
+
+func decodeValue[T any](codec Codec, s string) (T, error) {
+	var v T
+	if s == "" { // empty result
+		return v, nil
+	}
+	if !strings.HasPrefix(s, typedPrefix) {
+		return v, fmt.Errorf("typed value of bad format: %q", s)
+	}
+	err := codec.Unmarshal([]byte(s[len(typedPrefix):]), &v)
+	return v, err
+}
+
+// Fetch is the typed version of Client.Fetch2.
+// The value returned by fn is encoded by Options.Codec and prefixed before stored in cache, and decoded when read from cache.
+// If the codec reports the value as empty, it is cached with EmptyExpire, and the zero value of T is returned.
+// If fn returns ErrNotFound, it is cached with NotFoundExpire, and the zero value of T is returned with ErrNotFound.
+func Fetch[T any](ctx context.Context, c *Client, key string, expire time.Duration, fn func() (T, error)) (T, error) {
+	codec := c.codec()
+	s, err := c.Fetch2(ctx, key, expire, func() (string, error) {
+		v, err := fn()
+		if err != nil {
+			return "", err
+		}
+		return encodeValue(codec, v)
+	})
+	if errors.Is(err, ErrStale) {
+		v, derr := decodeValue[T](codec, s)
+		if derr != nil {
+			return v, derr
+		}
+		return v, err
+	}
+	if err != nil {
+		var v T
+		return v, err
+	}
+	return decodeValue[T](codec, s)
+}
+
+// FetchBatch is the typed version of Client.FetchBatch2.
+// The values returned by fn are encoded by Options.Codec before stored in cache, and decoded when read from cache.
+func FetchBatch[T any](ctx context.Context, c *Client, keys []string, expire time.Duration, fn func(idxs []int) (map[int]T, error)) (map[int]T, error) {
+	codec := c.codec()
+	rs, err := c.FetchBatch2(ctx, keys, expire, func(idxs []int) (map[int]string, error) {
+		values, err := fn(idxs)
+		if err != nil {
+			return nil, err
+		}
+		data := make(map[int]string, len(values))
+		for idx, v := range values {
+			s, err := encodeValue(codec, v)
+			if err != nil {
+				return nil, err
+			}
+			data[idx] = s
+		}
+		return data, nil
+	})
+	if err != nil && !valuesReturned(err) {
+		return nil, err
+	}
+	result := make(map[int]T, len(rs))
+	for idx, s := range rs {
+		v, err := decodeValue[T](codec, s)
+		if err != nil {
+			return nil, err
+		}
+		result[idx] = v
+	}
+	return result, err
+}
@@ -0,0 +1,121 @@
+package rockscache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type typedUser struct {
+	ID   int
+	Name string
+}
+
+func TestTypedFetch(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}, MsgpackCodec{}} {
+		clearCache()
+		rc := NewClient(rdb, NewDefaultOptions())
+		rc.Options.Codec = codec
+		expected := typedUser{ID: 1, Name: "user1"}
+		v, err := Fetch(ctx, rc, rdbKey, 60*time.Second, func() (typedUser, error) {
+			return expected, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v)
+
+		v, err = Fetch(ctx, rc, rdbKey, 60*time.Second, func() (typedUser, error) {
+			return typedUser{}, errors.New("should not be called")
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v)
+	}
+}
+
+func TestTypedFetchProtobuf(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	rc.Options.Codec = ProtobufCodec{}
+	fn := func() (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("value1"), nil
+	}
+	v, err := Fetch(ctx, rc, rdbKey, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v.GetValue())
+
+	v, err = Fetch(ctx, rc, rdbKey, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v.GetValue())
+
+	_, err = ProtobufCodec{}.Marshal("not a message")
+	assert.Error(t, err)
+}
+
+func TestTypedFetchProtobufDefault(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	rc.Options.Codec = ProtobufCodec{}
+	// a message with default values is encoded to no bytes, but it is not an empty result
+	v, err := Fetch(ctx, rc, rdbKey, 60*time.Second, func() (*wrapperspb.StringValue, error) {
+		return wrapperspb.String(""), nil
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	v, err = Fetch(ctx, rc, rdbKey, 60*time.Second, func() (*wrapperspb.StringValue, error) {
+		return nil, errors.New("should not be called")
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+	assert.Equal(t, "", v.GetValue())
+}
+
+func TestTypedFetchEmpty(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	v, err := Fetch(ctx, rc, rdbKey, 60*time.Second, func() (*typedUser, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+
+	// the empty result is cached with EmptyExpire
+	v, err = Fetch(ctx, rc, rdbKey, 60*time.Second, func() (*typedUser, error) {
+		return nil, errors.New("should not be called")
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+}
+
+func TestTypedFetchBatch(t *testing.T) {
+	clearCache()
+	rc := NewClient(rdb, NewDefaultOptions())
+	keys := genKeys(genIdxs(10))
+	fn := func(idxs []int) (map[int]*typedUser, error) {
+		values := make(map[int]*typedUser)
+		for _, i := range idxs {
+			if i%2 == 0 {
+				values[i] = &typedUser{ID: i}
+			}
+		}
+		return values, nil
+	}
+	v, err := FetchBatch(ctx, rc, keys, 60*time.Second, fn)
+	assert.Nil(t, err)
+	assert.Len(t, v, 10)
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			assert.Equal(t, &typedUser{ID: i}, v[i])
+		} else {
+			assert.Nil(t, v[i])
+		}
+	}
+
+	v2, err := FetchBatch(ctx, rc, keys, 60*time.Second, func(idxs []int) (map[int]*typedUser, error) {
+		return nil, errors.New("should not be called")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, v, v2)
+}
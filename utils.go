@@ -2,36 +2,19 @@ package rockscache
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"runtime/debug"
-	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-var verbose = false
-
-// SetVerbose sets verbose mode.
-func SetVerbose(v bool) {
-	verbose = v
-}
-
-func debugf(format string, args ...interface{}) {
-	if verbose {
-		log.Printf(format, args...)
-	}
-}
-func now() int64 {
-	return time.Now().Unix()
-}
-
-func callLua(ctx context.Context, rdb redis.Scripter, script *redis.Script, keys []string, args []interface{}) (interface{}, error) {
-	debugf("callLua: script=%s, keys=%v, args=%v", script.Hash(), keys, args)
+func callLua(ctx context.Context, rdb redis.Scripter, script *redis.Script, keys []string, args []interface{}, logger Logger) (interface{}, error) {
+	logger.Debug("callLua", "script", script.Hash(), "keys", keys, "args", args)
 	r := script.EvalSha(ctx, rdb, keys, args)
 	if redis.HasErrorPrefix(r.Err(), "NOSCRIPT") {
 		// try load script
 		if err := script.Load(ctx, rdb).Err(); err != nil {
-			debugf("callLua: load script failed: %v", err)
+			logger.Debug("callLua: load script failed", "err", err)
 			r = script.Eval(ctx, rdb, keys, args) // fallback to EVAL
 		} else {
 			r = script.EvalSha(ctx, rdb, keys, args) // retry EVALSHA
@@ -41,15 +24,24 @@ func callLua(ctx context.Context, rdb redis.Scripter, script *redis.Script, keys
 	if err == redis.Nil {
 		err = nil
 	}
-	debugf("callLua result: v=%v, err=%v", v, err)
+	logger.Debug("callLua result", "result", v, "err", err)
 	return v, err
 }
 
-func withRecover(f func()) {
-	defer func() {
-		if r := recover(); r != nil {
-			debug.PrintStack()
-		}
-	}()
+// withRecover calls f, and reports the panic of f to the logger
+func (c *Client) withRecover(f func()) {
+	defer c.recoverPanic()
 	f()
 }
+
+// recoverPanic should be deferred, the panic is reported to the logger with the stack
+func (c *Client) recoverPanic() {
+	if r := recover(); r != nil {
+		c.reportPanic(r)
+	}
+}
+
+// reportPanic reports the recovered panic r to the logger with the stack
+func (c *Client) reportPanic(r interface{}) {
+	c.logger().Error("recovered panic", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+}